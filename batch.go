@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxPublicIDs is the largest number of quakes that can be looked up in one request.
+const maxPublicIDs = 500
+
+// The Content-Type of the POST /quake request body selects the query.
+const (
+	publicIDsContent = "application/json"     // a JSON object with a publicID array.
+	polygonContent   = "application/geo+json" // a GeoJSON polygon.  application/vnd.geo+json is also accepted.
+)
+
+// /quake?publicID=2013p407387,2013p407399
+
+var quakesPublicIDD = &apidoc.Query{
+	Title:       "Quakes by publicID",
+	Description: "Information for several quakes, ordered by origin time (most recent first).",
+	Discussion: `<p>The <code>notFound</code> member of the FeatureCollection is an array of the requested quake IDs that
+	are not known.  Requests with some unknown quake IDs are still successful.</p>`,
+	Example:     "/quake?publicID=2013p407387,2013p407399",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`publicID`: `a comma separated list of up to <code>500</code> quake IDs e.g., <code>2013p407387,2014p715167</code>.`,
+	},
+	Props: mergeHTML(propsD, map[string]template.HTML{
+		`notFound`: `the requested quake IDs that are not known.  This is a member of the FeatureCollection, not a quake property.`,
+	}),
+}
+
+// POST /quake with a list of publicIDs in the request body.
+
+var quakesPublicIDPostD = &apidoc.Query{
+	Title:       "Quakes by publicID - POST",
+	Description: "Information for several quakes, ordered by origin time (most recent first).",
+	Discussion: `<p>This query uses http <code>POST</code> for lists of quake IDs that are too long for a URL.  The request body
+	must be a JSON object with a <code>publicID</code> array of up to <code>500</code> quake IDs, no larger than 1 MB, and
+	the <code>Content-Type</code> must be <code>application/json</code>.
+	The response is the same as for <code>/quake?publicID=(publicID,publicID,...)</code>.</p>
+	<pre>curl -X POST -H "Content-Type: application/json" -H "Accept: application/vnd.geo+json;version=1" -d '{"publicID":["2013p407387","2014p715167"]}' "http://...API-HOST.../quake"</pre>`,
+	Props: quakesPublicIDD.Props,
+}
+
+func quakesPublicID(w http.ResponseWriter, r *http.Request) {
+	quakesByPublicID(w, r, strings.Split(r.URL.Query().Get("publicID"), ","))
+}
+
+// badContentType is for POST /quake requests with a Content-Type that doesn't select a query.
+func badContentType(w http.ResponseWriter, r *http.Request) {
+	web.BadRequest(w, r, "Content-Type must be "+publicIDsContent+" for a list of quake IDs or "+polygonContent+" for a polygon.")
+}
+
+// quakesPublicIDPost writes the quakes for the list of publicIDs in the request body.
+func quakesPublicIDPost(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		web.BadRequest(w, r, "request body too large.")
+		return
+	}
+
+	var p struct {
+		PublicID []string `json:"publicID"`
+	}
+
+	if json.Unmarshal(body, &p) != nil || p.PublicID == nil {
+		web.BadRequest(w, r, "request body must be a JSON object with a publicID array.")
+		return
+	}
+
+	quakesByPublicID(w, r, p.PublicID)
+}
+
+// quakesByPublicID writes a FeatureCollection of the quakes in publicIDs with a notFound
+// member for the publicIDs that are not in the DB.
+func quakesByPublicID(w http.ResponseWriter, r *http.Request, publicIDs []string) {
+	ids, err := parsePublicIDs(publicIDs)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	gf, err := newGeoJSONFormat(r)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	var f quakeFilter
+	var p []string
+
+	for _, id := range ids {
+		p = append(p, f.bind("?::text", id))
+	}
+
+	list := strings.Join(p, ", ")
+
+	var d string
+
+	err = db.QueryRow(
+		`SELECT `+gf.collection()+`
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(`+gf.featureArray()+`, '[]') as features`+gf.bbox()+`,
+                         (SELECT COALESCE(array_to_json(array_agg(id ORDER BY id)), '[]') FROM unnest(ARRAY[`+list+`]) as id
+                         WHERE id NOT IN (SELECT publicid FROM qrt.quake_materialized where publicid in (`+list+`))) as "notFound"
+                         FROM (SELECT `+gf.quakeFeature(quakeProps)+`
+                         FROM qrt.quake_materialized as q where publicid in (`+list+`)
+                         order by origintime desc) as f ) as fc`, f.args...).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
+
+// parsePublicIDs validates publicIDs and returns them with duplicates removed.
+func parsePublicIDs(publicIDs []string) ([]string, error) {
+	var ids []string
+
+	for _, id := range publicIDs {
+		id = strings.TrimSpace(id)
+
+		if !publicIDRe.MatchString(id) {
+			return nil, fmt.Errorf("invalid publicID: %s", id)
+		}
+
+		if !contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+
+	switch {
+	case len(ids) == 0:
+		return nil, fmt.Errorf("at least one publicID is required.")
+	case len(ids) > maxPublicIDs:
+		return nil, fmt.Errorf("too many publicIDs, the maximum is %d.", maxPublicIDs)
+	}
+
+	return ids, nil
+}
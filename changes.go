@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"github.com/lib/pq"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// cursorFormat is the format of the next cursor and deletion times for /quake/changes.  Postgres stores microseconds.
+const cursorFormat = "2006-01-02T15:04:05.999999Z07:00"
+
+// changesLag is how old a change must be before /quake/changes serves it.  Modification and deletion times
+// are set before the change commits so changes can commit out of time order.  A change that commits
+// after a later change has been served would be missed by a client that has moved its cursor past it.
+// Only serving changes older than the longest expected write transaction avoids this.
+var changesLag = 2 * time.Minute
+
+var quakeChangesD = &apidoc.Query{
+	Title:       "Quake Changes",
+	Description: "quakes that have changed since a time, including deleted quakes.  For keeping a copy of the quake catalogue up to date.",
+	Discussion: `<p>Quakes with a <code>modificationTime</code> after <code>since</code> are returned in
+	modification time order (oldest first) with their current information.  Quakes that have been removed from the catalogue
+	are listed in <code>deleted</code>.  Use the value of <code>next</code> as <code>since</code> for the following request.
+	Changes made in the last two minutes are not returned until a later request so that changes which take time to be
+	saved are not missed.  If there are more changes than <code>limit</code> then all changes at the same time as the
+	last change are included so the response may contain more than <code>limit</code> quakes and deletions.</p>`,
+	Example:     "/quake/changes?since=2013-01-01T00:00:00Z",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`since`: `return changes after this time as an ISO8601 date time e.g., <code>2013-01-01T00:00:00Z</code>.
+		Usually the <code>next</code> value from a previous request.`,
+	},
+	Optional: map[string]template.HTML{
+		`limit`: limitD,
+	},
+	Props: mergeHTML(propsD, map[string]template.HTML{
+		`deleted`: `an array of quakes that have been deleted since the cursor, each with <code>publicID</code> and the deletion <code>time</code>.`,
+		`next`:    `the time to use as <code>since</code> for the next request.`,
+	}),
+}
+
+// quakeChanges is the response for /quake/changes.  It is a GeoJSON FeatureCollection with
+// additional members.
+type quakeChanges struct {
+	Type     string          `json:"type"`
+	Features json.RawMessage `json:"features"`
+	BBox     json.RawMessage `json:"bbox,omitempty"` // version 2 only.
+	Deleted  []tombstone     `json:"deleted"`
+	Next     string          `json:"next"`
+}
+
+type tombstone struct {
+	PublicID string `json:"publicID"`
+	Time     string `json:"time"`
+}
+
+func quakesChanges(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	since, err := parseTime(v.Get("since"))
+	if err != nil {
+		web.BadRequest(w, r, "Invalid since: "+v.Get("since"))
+		return
+	}
+
+	limit, err := parseLimit(v.Get("limit"))
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	gf, err := newGeoJSONFormat(r)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	// until is the time of the last of the first limit changes, to quakes or deletions, after
+	// since and older than changesLag.  It is null if nothing has changed.
+	var until pq.NullTime
+
+	err = db.QueryRow(`SELECT max(t) FROM (SELECT t FROM
+		(SELECT updatetime as t FROM qrt.quake_materialized WHERE updatetime > $1
+		UNION ALL SELECT deletetime as t FROM qrt.quake_tombstone WHERE deletetime > $1) as c
+		WHERE t <= now() - $3 * interval '1 second'
+		ORDER BY t ASC LIMIT $2) as u`, since, limit, changesLag.Seconds()).Scan(&until)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	c := quakeChanges{
+		Type:     "FeatureCollection",
+		Features: json.RawMessage(`[]`),
+		Deleted:  []tombstone{},
+		Next:     since.UTC().Format(cursorFormat),
+	}
+
+	if !until.Valid {
+		writeQuakeChanges(w, r, c)
+		return
+	}
+
+	c.Next = until.Time.UTC().Format(cursorFormat)
+
+	var d string
+	err = db.QueryRow(
+		`SELECT `+gf.collection()+`
+                         FROM (SELECT COALESCE(`+gf.featureArray()+`, '[]') as features`+gf.bbox()+`
+                         FROM (SELECT `+gf.quakeFeature(quakeProps)+`
+                         FROM qrt.quake_materialized as q where updatetime > $1 AND updatetime <= $2
+                         ORDER BY updatetime ASC, publicid ) as f ) as fc`, since, until.Time).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	if err = json.Unmarshal([]byte(d), &c); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	rows, err := db.Query(`SELECT publicid, deletetime FROM qrt.quake_tombstone
+		WHERE deletetime > $1 AND deletetime <= $2 ORDER BY deletetime ASC, publicid`, since, until.Time)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t tombstone
+		var tm time.Time
+
+		if err = rows.Scan(&t.PublicID, &tm); err != nil {
+			web.ServiceUnavailable(w, r, err)
+			return
+		}
+
+		t.Time = tm.UTC().Format(cursorFormat)
+		c.Deleted = append(c.Deleted, t)
+	}
+	if err = rows.Err(); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	writeQuakeChanges(w, r, c)
+}
+
+// writeQuakeChanges writes c as JSON.
+func writeQuakeChanges(w http.ResponseWriter, r *http.Request, c quakeChanges) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(c); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	web.OkBuf(w, r, &b)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /quake/2013p407387/contours
+
+// maxContours is the number of quakes with contours kept in the cache.
+const maxContours = 500
+
+var quakeContoursD = &apidoc.Query{
+	Title:       "Intensity Contours",
+	Description: "the predicted intensity for a quake as MultiPolygons for each MMI band.",
+	Discussion: `<p>The intensity is calculated using the same <a href="https://github.com/GeoNet/quakes/issues/159">attenuation</a>
+	as <code>regionIntensity</code>.  There is a MultiPolygon for each MMI band (e.g., MMI 4 is from 4 up to 5) with a predicted MMI of at least 3,
+	ordered by MMI.  If the quake depth or magnitude is not known then there are no Features in the response.</p>`,
+	Example:     "/quake/2013p407387/contours",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		`publicID`: `a valid quake ID e.g., <code>2013p407387</code>.`,
+	},
+	Props: map[string]template.HTML{
+		`mmi`:       `the lower bound of the <a href="http://info.geonet.org.nz/x/w4IO">Modified Mercalli Intensity (MMI)</a> band e.g., <code>4</code>.`,
+		`intensity`: `the <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> for the band e.g., <code>light</code>.`,
+		`radius`:    `the distance (km) from the epicenter to the outer edge of the band.`,
+	},
+}
+
+var contours = contourCache{
+	quakes: make(map[string]contourEntry),
+}
+
+// contourCache holds the contours for quakes.  The contours are calculated again when the quake updatetime changes.
+type contourCache struct {
+	sync.Mutex
+	quakes map[string]contourEntry
+}
+
+type contourEntry struct {
+	updateTime time.Time
+	b          []byte
+}
+
+// get returns the contours for publicID if they are in the cache for updateTime.
+func (c *contourCache) get(publicID string, updateTime time.Time) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.quakes[publicID]
+	if !ok || !e.updateTime.Equal(updateTime) {
+		return nil, false
+	}
+
+	return e.b, true
+}
+
+// add caches b for publicID.  The cache is emptied when it is full.
+func (c *contourCache) add(publicID string, updateTime time.Time, b []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.quakes[publicID]; !ok && len(c.quakes) >= maxContours {
+		c.quakes = make(map[string]contourEntry)
+	}
+
+	c.quakes[publicID] = contourEntry{updateTime: updateTime, b: b}
+}
+
+func quakeContours(w http.ResponseWriter, r *http.Request) {
+	publicID := strings.TrimSuffix(r.URL.Path[quakeLen:], "/contours")
+
+	var depth, magnitude float64
+	var updateTime time.Time
+
+	err := db.QueryRow("select depth, magnitude, updatetime FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&depth, &magnitude, &updateTime)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	if b, ok := contours.get(publicID, updateTime); ok {
+		web.Ok(w, r, &b)
+		return
+	}
+
+	var b []byte
+
+	// -9.0 is the unknown value for depth and magnitude.
+	switch {
+	case depth == -9.0 || magnitude == -9.0:
+		b = []byte(`{"type":"FeatureCollection","features":[]}`)
+	default:
+		// Minimum depth to avoid numeric instability.  Matches qrt.mmi_in_region.
+		if depth < 5.0 {
+			depth = 5.0
+		}
+
+		var d string
+
+		err = db.QueryRow(
+			`SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
+                         FROM (`+contourSQL("(SELECT origin_geom FROM qrt.quake_materialized WHERE publicid = $1)", "$2::numeric", "$3::numeric")+`) as f ) as fc`,
+			publicID, depth, magnitude).Scan(&d)
+		if err != nil {
+			web.ServiceUnavailable(w, r, err)
+			return
+		}
+
+		b = []byte(d)
+	}
+
+	contours.add(publicID, updateTime, b)
+
+	web.Ok(w, r, &b)
+}
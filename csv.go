@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// csvColumns is the column order for quake CSV.  Properties that are not in this list
+// are added as columns after these, sorted by name.  longitude and latitude are from the geometry.
+var csvColumns = []string{
+	`publicID`,
+	`time`,
+	`longitude`,
+	`latitude`,
+	`depth`,
+	`magnitude`,
+	`type`,
+	`agency`,
+	`locality`,
+	`intensity`,
+	`regionIntensity`,
+	`quality`,
+	`modificationTime`,
+}
+
+// /quake/2013p407387 with Accept: text/csv;version=1
+
+var quakeCSVD = &apidoc.Query{
+	Accept:      web.V1CSV,
+	Title:       "Quake - CSV",
+	Description: "Information for a single quake as CSV.",
+	Discussion: `<p>The first row is a header row with the column names.  The columns are the same as the 
+	quake properties with the addition of <code>longitude</code> and <code>latitude</code>.</p>`,
+	Example:     "/quake/2013p407387",
+	ExampleHost: exHost,
+	URI:         "/quake/(publicID)",
+	Params: map[string]template.HTML{
+		"publicID": `a valid quake ID e.g., <code>2014p715167</code>`,
+	},
+	Props: mergeHTML(propsD, map[string]template.HTML{
+		`longitude`: `the longitude of the epicenter.`,
+		`latitude`:  `the latitude of the epicenter.`,
+	}),
+}
+
+// /quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z with Accept: text/csv;version=1
+
+var quakesCSVD = &apidoc.Query{
+	Accept:      web.V1CSV,
+	Title:       "Quakes - CSV",
+	Description: "Quake lists as CSV.",
+	Discussion: `<p>All of the quake list queries can be returned as CSV by setting the <code>Accept</code> header.  
+	The query parameters are the same as for the GeoJSON version of the query.  
+	The first row is a header row with the column names.  The columns are the same as the quake properties 
+	for the query with the addition of <code>longitude</code> and <code>latitude</code>.</p>
+	<pre>curl -H "Accept: text/csv;version=1" "http://...API-HOST.../quake?startTime=2013-05-30T00:00:00Z&amp;endTime=2013-05-31T00:00:00Z"</pre>`,
+	Example:     "/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z",
+	ExampleHost: exHost,
+	URI:         "/quake?(query)",
+	Params: map[string]template.HTML{
+		"query": `the query parameters for any of the GeoJSON quake list queries.`,
+	},
+	Props: mergeHTML(propsD, map[string]template.HTML{
+		`longitude`: `the longitude of the epicenter.`,
+		`latitude`:  `the latitude of the epicenter.`,
+	}),
+}
+
+// geoJSONResponse captures a GeoJSON response so that it can be converted to another format.
+type geoJSONResponse struct {
+	http.ResponseWriter
+	status int
+	b      bytes.Buffer
+}
+
+func (g *geoJSONResponse) WriteHeader(status int) {
+	g.status = status
+}
+
+func (g *geoJSONResponse) Write(b []byte) (int, error) {
+	return g.b.Write(b)
+}
+
+// captureGeoJSON calls h and returns the GeoJSON that it writes.  If h writes an error response then
+// it is written to w unchanged and ok is false.
+func captureGeoJSON(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) (b []byte, ok bool) {
+	g := &geoJSONResponse{ResponseWriter: w, status: http.StatusOK}
+
+	h(g, r)
+
+	if g.status != http.StatusOK {
+		w.WriteHeader(g.status)
+		g.b.WriteTo(w)
+		return
+	}
+
+	return g.b.Bytes(), true
+}
+
+// geoJSONToCSV calls h and converts the GeoJSON FeatureCollection that it writes to CSV.  The columns are
+// the properties in the docs for the query.  Queries without docs have the quake properties.
+// Error responses from h are written to w unchanged.
+func geoJSONToCSV(w http.ResponseWriter, r *http.Request, doc *apidoc.Query, h http.HandlerFunc) {
+	props := propsD
+	if doc != nil {
+		props = doc.Props
+	}
+
+	j, ok := captureGeoJSON(w, r, h)
+	if !ok {
+		return
+	}
+
+	b, err := featuresToCSV(j, csvHeader(props))
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// h has already counted the response in the metrics.
+	b.WriteTo(w)
+}
+
+// csvHeader returns the CSV columns for the Feature properties documented in props; the csvColumns
+// that are in props, followed by the other properties sorted by name.  Members of the FeatureCollection
+// that are documented with the properties and quake properties that are only in version 2 are not columns.
+func csvHeader(props map[string]template.HTML) []string {
+	props = withoutV2(props)
+
+	var cols []string
+
+	for _, c := range csvColumns {
+		if _, ok := props[c]; ok || c == "longitude" || c == "latitude" {
+			cols = append(cols, c)
+		}
+	}
+
+	var extra []string
+	for k := range props {
+		if !contains(csvColumns, k) && !contains(collectionMembers, k) {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+
+	return append(cols, extra...)
+}
+
+// featuresToCSV converts a GeoJSON FeatureCollection of Point features to CSV with the columns cols.
+// Properties that are not in cols are not included.
+func featuresToCSV(j []byte, cols []string) (*bytes.Buffer, error) {
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64
+			}
+			Properties map[string]interface{}
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+
+	if err := d.Decode(&fc); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	c := csv.NewWriter(&b)
+
+	if err := c.Write(cols); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fc.Features {
+		if f.Properties == nil {
+			f.Properties = make(map[string]interface{})
+		}
+
+		if len(f.Geometry.Coordinates) >= 2 {
+			f.Properties["longitude"] = strconv.FormatFloat(f.Geometry.Coordinates[0], 'f', -1, 64)
+			f.Properties["latitude"] = strconv.FormatFloat(f.Geometry.Coordinates[1], 'f', -1, 64)
+		}
+
+		row := make([]string, len(cols))
+		for i, k := range cols {
+			if v := f.Properties[k]; v != nil {
+				row[i] = fmt.Sprintf("%v", v)
+			}
+		}
+
+		if err := c.Write(row); err != nil {
+			return nil, err
+		}
+	}
+
+	c.Flush()
+
+	return &b, c.Error()
+}
+
+func contains(s []string, e string) bool {
+	for _, v := range s {
+		if v == e {
+			return true
+		}
+	}
+
+	return false
+}
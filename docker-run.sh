@@ -44,5 +44,8 @@
 #
 # token for Logentries.
 # GEONET_REST_LOGENTRIES_TOKEN=
+#
+# the largest number of quakes that can be requested.
+# GEONET_REST_MAX_QUAKES=10000
 
-docker run -e "GEONET_REST_DATABASE_HOST=localhost" -e "GEONET_REST_DATABASE_PASSWORD=test" -e "GEONET_REST_DATABASE_SSL_MODE=disable" -e "GEONET_REST_DATABASE_MAX_OPEN_CONNS=30" -e "GEONET_REST_DATABASE_MAX_IDLE_CONNS=20" -e "GEONET_REST_WEB_SERVER_PORT=8080" -e "GEONET_REST_WEB_SERVER_CNAME=localhost" -e "GEONET_REST_WEB_SERVER_PRODUCTION=false" -e "GEONET_REST_LIBRATO_USER=" -e "GEONET_REST_LIBRATO_KEY=" -e "GEONET_REST_LIBRATO_SOURCE=" -e "GEONET_REST_LOGENTRIES_TOKEN=" busybox
+docker run -e "GEONET_REST_DATABASE_HOST=localhost" -e "GEONET_REST_DATABASE_PASSWORD=test" -e "GEONET_REST_DATABASE_SSL_MODE=disable" -e "GEONET_REST_DATABASE_MAX_OPEN_CONNS=30" -e "GEONET_REST_DATABASE_MAX_IDLE_CONNS=20" -e "GEONET_REST_WEB_SERVER_PORT=8080" -e "GEONET_REST_WEB_SERVER_CNAME=localhost" -e "GEONET_REST_WEB_SERVER_PRODUCTION=false" -e "GEONET_REST_LIBRATO_USER=" -e "GEONET_REST_LIBRATO_KEY=" -e "GEONET_REST_LIBRATO_SOURCE=" -e "GEONET_REST_LOGENTRIES_TOKEN=" -e "GEONET_REST_MAX_QUAKES=10000" busybox
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Implements the query, version, and application.wadl methods of the FDSN event web service
+// http://www.fdsn.org/webservices/
+
+const (
+	fdsnVersion = "1.2.0"
+	fdsnPath    = "/fdsnws/event/1/"
+	xmlContent  = "application/xml"
+	textContent = "text/plain; charset=utf-8"
+	kmPerDegree = 111.19
+)
+
+var fdsnDoc = apidoc.Endpoint{Title: "FDSN Event",
+	Description: `An <a href="http://www.fdsn.org/webservices/">FDSN event web service</a> (fdsnws-event) for use with FDSN compatible tools.`,
+}
+
+// fdsnOrder maps the orderby query parameter to SQL.  publicid breaks ties so that pages with offset are stable.
+var fdsnOrder = map[string]string{
+	"time":          "origintime desc, publicid",
+	"time-asc":      "origintime asc, publicid",
+	"magnitude":     "magnitude desc, publicid",
+	"magnitude-asc": "magnitude asc, publicid",
+}
+
+var fdsnQueryD = &apidoc.Query{
+	Title:       "FDSN Event Query",
+	Description: "Query for quakes using the FDSN event web service specification.",
+	Discussion: `<p>The query parameters and responses follow the 
+	<a href="http://www.fdsn.org/webservices/FDSN-WS-Specifications-1.1.pdf">FDSN web service specification</a>.  
+	Use <code>format</code> to select the response format.  The <code>Accept</code> header must allow the format e.g., <code>*/*</code>.  
+	Abbreviated parameter names e.g., <code>start</code>, <code>minlat</code>, <code>minmag</code> may be used.  
+	If there are no quakes for the query a 204 (or 404 if <code>nodata=404</code>) is returned.  
+	Deleted and duplicate quakes are not included.</p>`,
+	Example:     "/fdsnws/event/1/query?starttime=2013-05-30T00:00:00&endtime=2013-05-31T00:00:00&format=text",
+	ExampleHost: exHost,
+	Optional: withFDSNAbbr(map[string]template.HTML{
+		`starttime`:     `limit to quakes on or after the start time e.g., <code>2013-05-30T00:00:00</code>.`,
+		`endtime`:       `limit to quakes on or before the end time e.g., <code>2013-05-31T00:00:00</code>.`,
+		`minlatitude`:   `limit to quakes with a latitude larger than or equal to the minimum.`,
+		`maxlatitude`:   `limit to quakes with a latitude smaller than or equal to the maximum.`,
+		`minlongitude`:  `limit to quakes with a longitude larger than or equal to the minimum.`,
+		`maxlongitude`:  `limit to quakes with a longitude smaller than or equal to the maximum.  May be less than <code>minlongitude</code> to cross the 180&deg; meridian.`,
+		`latitude`:      `latitude of the point for a radius search.`,
+		`longitude`:     `longitude of the point for a radius search.`,
+		`minradius`:     `limit to quakes at least this many degrees from the point.`,
+		`maxradius`:     `limit to quakes no more than this many degrees from the point.`,
+		`mindepth`:      `limit to quakes with a depth (km) larger than or equal to the minimum.`,
+		`maxdepth`:      `limit to quakes with a depth (km) smaller than or equal to the maximum.`,
+		`minmagnitude`:  `limit to quakes with a magnitude larger than or equal to the minimum.`,
+		`maxmagnitude`:  `limit to quakes with a magnitude smaller than or equal to the maximum.`,
+		`magnitudetype`: `limit to quakes with this magnitude type e.g., <code>ML</code>.`,
+		`eventid`:       `select a single quake by publicID e.g., <code>2013p407387</code>.`,
+		`updatedafter`:  `limit to quakes updated after this time.`,
+		`orderby`:       `<code>time</code> (default), <code>time-asc</code>, <code>magnitude</code>, or <code>magnitude-asc</code>.`,
+		`limit`:         limitD,
+		`offset`:        `return quakes starting at this offset (starting at 1).`,
+		`format`:        `<code>xml</code> (QuakeML 1.2, default) or <code>text</code>.`,
+		`nodata`:        `the http status code to return when there are no quakes; <code>204</code> (default) or <code>404</code>.`,
+	}),
+	Props: map[string]template.HTML{
+		`xml`: `QuakeML 1.2.  See the QuakeML quake query for details.`,
+		`text`: `a header line then one line per quake with <code>|</code> separated fields: 
+		<code>EventID|Time|Latitude|Longitude|Depth/km|Author|Catalog|Contributor|ContributorID|MagType|Magnitude|MagAuthor|EventLocationName</code>.`,
+	},
+}
+
+var fdsnVersionD = &apidoc.Query{
+	Title:       "FDSN Event Version",
+	Description: "The version of the FDSN event web service specification that is implemented.",
+}
+
+var fdsnWADLD = &apidoc.Query{
+	Title:       "FDSN Event WADL",
+	Description: "Web Application Description Language (WADL) for the FDSN event web service.",
+}
+
+// fdsnAbbr maps the abbreviated FDSN query parameter names to the full names.
+var fdsnAbbr = map[string]string{
+	"start":   "starttime",
+	"end":     "endtime",
+	"minlat":  "minlatitude",
+	"maxlat":  "maxlatitude",
+	"minlon":  "minlongitude",
+	"maxlon":  "maxlongitude",
+	"lat":     "latitude",
+	"lon":     "longitude",
+	"minmag":  "minmagnitude",
+	"maxmag":  "maxmagnitude",
+	"magtype": "magnitudetype",
+}
+
+// withFDSNAbbr returns the docs for the FDSN query parameters d with the docs for the abbreviated names added.
+func withFDSNAbbr(d map[string]template.HTML) map[string]template.HTML {
+	m := make(map[string]template.HTML)
+
+	for k, v := range d {
+		m[k] = v
+	}
+
+	for k, v := range fdsnAbbr {
+		m[k] = template.HTML(`the same as <code>` + v + `</code>.`)
+	}
+
+	return m
+}
+
+func fdsnVersionHandler(w http.ResponseWriter, r *http.Request) {
+	b := []byte(fdsnVersion)
+	web.Ok(w, r, &b)
+}
+
+func fdsnWADLHandler(w http.ResponseWriter, r *http.Request) {
+	b := []byte(fdsnWADL)
+	web.Ok(w, r, &b)
+}
+
+func fdsnQuery(w http.ResponseWriter, r *http.Request) {
+	v, err := fdsnParams(r.URL.Query())
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	f, err := fdsnFilter(v)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	order := "time"
+	if s := v.Get("orderby"); s != "" {
+		order = s
+	}
+	if _, ok := fdsnOrder[order]; !ok {
+		web.BadRequest(w, r, "Invalid orderby: "+order)
+		return
+	}
+
+	limit, err := parseLimit(v.Get("limit"))
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	offset := 1
+	if s := v.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 1 {
+			web.BadRequest(w, r, "Invalid offset, must be 1 or greater: "+s)
+			return
+		}
+	}
+
+	format := v.Get("format")
+	if format == "" {
+		format = "xml"
+	}
+	if format != "xml" && format != "text" {
+		web.BadRequest(w, r, "Invalid format, must be xml or text: "+format)
+		return
+	}
+
+	// the route negotiated one of the formats.  The format that was asked for must be acceptable too.
+	ct := xmlContent
+	if format == "text" {
+		ct = textContent
+	}
+	if _, ok := negotiate(strings.Join(r.Header["Accept"], ","), []string{ct}); !ok {
+		web.NotAcceptable(w, r, "format="+format+" is only available as: "+ct)
+		return
+	}
+
+	nodata := v.Get("nodata")
+	if nodata != "" && nodata != "204" && nodata != "404" {
+		web.BadRequest(w, r, "Invalid nodata, must be 204 or 404: "+nodata)
+		return
+	}
+
+	q, err := f.quakeRows(fdsnOrder[order], limit, offset-1)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	if len(q) == 0 {
+		if nodata == "404" {
+			web.NotFound(w, r, "no quakes found for the query.")
+			return
+		}
+		web.OkTrack(w, r)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", ct)
+
+	var b bytes.Buffer
+
+	switch format {
+	case "text":
+		fdsnText(&b, q)
+	case "xml":
+
+		qml := newQuakeML()
+		for _, e := range q {
+			qml.EventParameters.Events = append(qml.EventParameters.Events, e.event())
+		}
+
+		b.WriteString(xml.Header)
+		if err := xml.NewEncoder(&b).Encode(qml); err != nil {
+			web.ServiceUnavailable(w, r, err)
+			return
+		}
+	}
+
+	web.OkBuf(w, r, &b)
+}
+
+// fdsnParams returns a copy of v with abbreviated parameter names replaced with the full names.
+// It is an error to use both the full and abbreviated name for a parameter.
+func fdsnParams(v url.Values) (url.Values, error) {
+	p := make(url.Values)
+
+	for k, val := range v {
+		if full, ok := fdsnAbbr[k]; ok {
+			if _, ok := v[full]; ok {
+				return p, fmt.Errorf("only one of %s and %s may be used.", k, full)
+			}
+			k = full
+		}
+		p[k] = val
+	}
+
+	return p, nil
+}
+
+// fdsnFilter returns a quake filter for the FDSN query parameters in v.
+func fdsnFilter(v url.Values) (f quakeFilter, err error) {
+	f.add("status != 'deleted'")
+
+	if s := v.Get("eventid"); s != "" {
+		if !publicIDRe.MatchString(s) {
+			return f, fmt.Errorf("Invalid eventid: %s", s)
+		}
+		f.add("publicid = ?", s)
+	}
+
+	if err = f.addTimeWindow(url.Values{"startTime": v["starttime"], "endTime": v["endtime"]}); err != nil {
+		return
+	}
+
+	if err = f.addRanges(url.Values{
+		"minMag":   v["minmagnitude"],
+		"maxMag":   v["maxmagnitude"],
+		"minDepth": v["mindepth"],
+		"maxDepth": v["maxdepth"],
+	}); err != nil {
+		return
+	}
+
+	if s := v.Get("magnitudetype"); s != "" {
+		f.add("lower(magnitudetype) = lower(?)", s)
+	}
+
+	if s := v.Get("updatedafter"); s != "" {
+		var t time.Time
+		if t, err = parseTime(s); err != nil {
+			return f, fmt.Errorf("Invalid updatedafter: %s", s)
+		}
+		f.add("updatetime > ?", t)
+	}
+
+	// rectangle
+	if v.Get("minlatitude") != "" || v.Get("maxlatitude") != "" || v.Get("minlongitude") != "" || v.Get("maxlongitude") != "" {
+		var c [4]float64
+		for i, p := range []struct {
+			name string
+			def  float64
+		}{
+			{"minlongitude", -180},
+			{"minlatitude", -90},
+			{"maxlongitude", 180},
+			{"maxlatitude", 90},
+		} {
+			if c[i], err = fdsnFloat(v, p.name, p.def); err != nil {
+				return
+			}
+		}
+
+		if err = f.addEnvelope(c[0], c[1], c[2], c[3]); err != nil {
+			return f, fmt.Errorf("Invalid rectangle, %s.", err.Error())
+		}
+	}
+
+	// radius
+	if v.Get("latitude") != "" || v.Get("longitude") != "" || v.Get("minradius") != "" || v.Get("maxradius") != "" {
+		var lat, lon, min, max float64
+
+		if lat, err = fdsnFloat(v, "latitude", 0); err != nil {
+			return
+		}
+		if lon, err = fdsnFloat(v, "longitude", 0); err != nil {
+			return
+		}
+		if min, err = fdsnFloat(v, "minradius", 0); err != nil {
+			return
+		}
+		if max, err = fdsnFloat(v, "maxradius", 180); err != nil {
+			return
+		}
+
+		switch {
+		case lat < -90 || lat > 90:
+			return f, fmt.Errorf("Invalid latitude: %f", lat)
+		case lon < -180 || lon > 180:
+			return f, fmt.Errorf("Invalid longitude: %f", lon)
+		case min < 0 || max > 180 || min > max:
+			return f, fmt.Errorf("Invalid radius, must be 0 <= minradius <= maxradius <= 180.")
+		}
+
+		if min > 0 {
+			f.add("NOT ST_DWithin(q.origin_geom::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", lon, lat, min*kmPerDegree*1000)
+		}
+		if max < 180 {
+			f.add("ST_DWithin(q.origin_geom::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", lon, lat, max*kmPerDegree*1000)
+		}
+	}
+
+	return
+}
+
+// fdsnFloat returns the query parameter name from v as a float64 or def if it is not set.
+func fdsnFloat(v url.Values, name string, def float64) (float64, error) {
+	s := v.Get(name)
+	if s == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return f, fmt.Errorf("Invalid %s: %s", name, s)
+	}
+
+	return f, nil
+}
+
+// fdsnText writes quakes in the FDSN text format.
+func fdsnText(b *bytes.Buffer, q []quakeRow) {
+	b.WriteString("#EventID|Time|Latitude|Longitude|Depth/km|Author|Catalog|Contributor|ContributorID|MagType|Magnitude|MagAuthor|EventLocationName\n")
+
+	for _, e := range q {
+		b.WriteString(strings.Join([]string{
+			e.publicID,
+			e.originTime.UTC().Format("2006-01-02T15:04:05.999999"),
+			strconv.FormatFloat(e.latitude, 'f', -1, 64),
+			strconv.FormatFloat(e.longitude, 'f', -1, 64),
+			strconv.FormatFloat(e.depth, 'f', -1, 64),
+			e.agency,
+			"GeoNet",
+			"GeoNet",
+			e.publicID,
+			e.magnitudeType,
+			strconv.FormatFloat(e.magnitude, 'f', -1, 64),
+			e.agency,
+			e.locality,
+		}, "|"))
+		b.WriteString("\n")
+	}
+}
+
+// quakeRows returns at most limit quakes selected by the filter, starting at offset (from 0), ordered by order.
+// Duplicate quakes are never included.
+func (f *quakeFilter) quakeRows(order string, limit, offset int) (q []quakeRow, err error) {
+	where := append([]string{"status != 'duplicate'"}, f.where...)
+
+	rows, err := db.Query(`SELECT publicid, origintime, ST_Y(origin_geom), ST_X(origin_geom), depth, magnitude, magnitudetype,
+		usedphasecount, magnitudestationcount, type, status, agency, updatetime, COALESCE(locality, '')
+		FROM qrt.quake_materialized as q where `+strings.Join(where, " AND ")+`
+		order by `+order+` limit `+strconv.Itoa(limit)+` offset `+strconv.Itoa(offset), f.args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e quakeRow
+
+		err = rows.Scan(&e.publicID, &e.originTime, &e.latitude, &e.longitude, &e.depth, &e.magnitude, &e.magnitudeType,
+			&e.usedPhaseCount, &e.magnitudeStationCount, &e.typ, &e.status, &e.agency, &e.updateTime, &e.locality)
+		if err != nil {
+			return
+		}
+
+		q = append(q, e)
+	}
+
+	err = rows.Err()
+
+	return
+}
+
+// fdsnWADL describes the query parameters supported by fdsnQuery.
+const fdsnWADL = `<?xml version="1.0" encoding="UTF-8"?>
+<application xmlns="http://wadl.dev.java.net/2009/02" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+  <resources base="/fdsnws/event/1/">
+    <resource path="query">
+      <method name="GET" id="query">
+        <request>
+          <param name="starttime" style="query" type="xsd:dateTime"/>
+          <param name="endtime" style="query" type="xsd:dateTime"/>
+          <param name="minlatitude" style="query" type="xsd:double" default="-90.0"/>
+          <param name="maxlatitude" style="query" type="xsd:double" default="90.0"/>
+          <param name="minlongitude" style="query" type="xsd:double" default="-180.0"/>
+          <param name="maxlongitude" style="query" type="xsd:double" default="180.0"/>
+          <param name="latitude" style="query" type="xsd:double" default="0.0"/>
+          <param name="longitude" style="query" type="xsd:double" default="0.0"/>
+          <param name="minradius" style="query" type="xsd:double" default="0.0"/>
+          <param name="maxradius" style="query" type="xsd:double" default="180.0"/>
+          <param name="mindepth" style="query" type="xsd:double"/>
+          <param name="maxdepth" style="query" type="xsd:double"/>
+          <param name="minmagnitude" style="query" type="xsd:double"/>
+          <param name="maxmagnitude" style="query" type="xsd:double"/>
+          <param name="magnitudetype" style="query" type="xsd:string"/>
+          <param name="eventid" style="query" type="xsd:string"/>
+          <param name="updatedafter" style="query" type="xsd:dateTime"/>
+          <param name="limit" style="query" type="xsd:int"/>
+          <param name="offset" style="query" type="xsd:int" default="1"/>
+          <param name="orderby" style="query" type="xsd:string" default="time">
+            <option value="time"/>
+            <option value="time-asc"/>
+            <option value="magnitude"/>
+            <option value="magnitude-asc"/>
+          </param>
+          <param name="format" style="query" type="xsd:string" default="xml">
+            <option value="xml" mediaType="application/xml"/>
+            <option value="text" mediaType="text/plain"/>
+          </param>
+          <param name="nodata" style="query" type="xsd:int" default="204">
+            <option value="204"/>
+            <option value="404"/>
+          </param>
+        </request>
+        <response status="200">
+          <representation mediaType="application/xml"/>
+          <representation mediaType="text/plain"/>
+        </response>
+        <response status="204 400 404 503">
+          <representation mediaType="text/plain"/>
+        </response>
+      </method>
+    </resource>
+    <resource path="version">
+      <method name="GET">
+        <response>
+          <representation mediaType="text/plain"/>
+        </response>
+      </method>
+    </resource>
+    <resource path="application.wadl">
+      <method name="GET">
+        <response>
+          <representation mediaType="application/xml"/>
+        </response>
+      </method>
+    </resource>
+  </resources>
+</application>
+`
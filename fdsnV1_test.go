@@ -0,0 +1,201 @@
+//# FDSN Event
+//
+//##/fdsnws/event/1
+//
+// An FDSN event web service (fdsnws-event) for use with FDSN compatible tools.
+// See http://www.fdsn.org/webservices/
+//
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/GeoNet/web/webtest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//## FDSN Event Query
+//
+// **GET /fdsnws/event/1/query?(parameters)**
+//
+// Query for quakes using the FDSN event web service specification.  The `Accept` header is ignored,
+// use `format` to select the response format.  Deleted and duplicate quakes are not included.
+//
+//### Parameters
+//
+// * `starttime`, `endtime` - limit to quakes in the time window e.g., `2013-05-30T00:00:00`.
+// * `minlatitude`, `maxlatitude`, `minlongitude`, `maxlongitude` - limit to quakes in a rectangle.
+// * `latitude`, `longitude`, `minradius`, `maxradius` - limit to quakes within a distance (degrees) of a point.
+// * `mindepth`, `maxdepth`, `minmagnitude`, `maxmagnitude`, `magnitudetype` - limit by depth and magnitude.
+// * `eventid` - select a single quake by publicID.
+// * `updatedafter` - limit to quakes updated after this time.
+// * `orderby` - `time` (default), `time-asc`, `magnitude`, or `magnitude-asc`.
+// * `limit`, `offset` - page through the results.
+// * `format` - `xml` (QuakeML 1.2, default) or `text`.
+// * `nodata` - `204` (default) or `404` when there are no quakes.
+//
+//### Example request:
+//
+// `/fdsnws/event/1/query?starttime=2013-05-30T00:00:00&endtime=2013-05-31T00:00:00&format=text`
+//
+func TestFDSNQueryV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: textContent,
+		URI:    "/fdsnws/event/1/query?eventid=2013p407387&format=text",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := strings.Split(strings.TrimSpace(string(b)), "\n")
+
+	if len(l) != 2 {
+		t.Fatalf("expected a header and one quake, got %d lines", len(l))
+	}
+
+	if !strings.HasPrefix(l[0], "#EventID|Time|") {
+		t.Errorf("incorrect header: %s", l[0])
+	}
+
+	f := strings.Split(l[1], "|")
+
+	if len(f) != 13 {
+		t.Fatalf("expected 13 fields, got %d", len(f))
+	}
+
+	if f[0] != "2013p407387" {
+		t.Errorf("incorrect EventID: %s", f[0])
+	}
+
+	if f[1] != "2013-05-30T15:15:37.812174" {
+		t.Errorf("incorrect Time: %s", f[1])
+	}
+
+	if f[12] != "15 km south-east of Oxford" {
+		t.Errorf("incorrect EventLocationName: %s", f[12])
+	}
+
+	c = webtest.Content{
+		Accept: xmlContent,
+		URI:    "/fdsnws/event/1/query?eventid=2013p407387",
+	}
+
+	if b, err = c.Get(ts); err != nil {
+		t.Fatal(err)
+	}
+
+	var q struct {
+		EventParameters eventParameters `xml:"eventParameters"`
+	}
+
+	if err = xml.Unmarshal(b, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.EventParameters.Events) != 1 {
+		t.Fatalf("Found wrong number of events: %d", len(q.EventParameters.Events))
+	}
+
+	if q.EventParameters.Events[0].PublicID != "smi:nz.org.geonet/2013p407387" {
+		t.Errorf("incorrect publicID: %s", q.EventParameters.Events[0].PublicID)
+	}
+
+	// no quakes is a 204 by default.
+	res, err := http.Get(ts.URL + "/fdsnws/event/1/query?eventid=2013p407399")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 for no quakes, got %d", res.StatusCode)
+	}
+}
+
+//## FDSN Event Version
+//
+// **GET /fdsnws/event/1/version**
+//
+// The version of the FDSN event web service specification that is implemented.
+//
+func TestFDSNVersionV1(t *testing.T) {
+	// tests are done in routes.  This is just a handle for the docs.
+}
+
+//## FDSN Event WADL
+//
+// **GET /fdsnws/event/1/application.wadl**
+//
+// Web Application Description Language (WADL) for the FDSN event web service.
+//
+func TestFDSNWADLV1(t *testing.T) {
+	var a struct {
+		XMLName xml.Name
+	}
+
+	if err := xml.Unmarshal([]byte(fdsnWADL), &a); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.XMLName.Local != "application" {
+		t.Errorf("incorrect WADL root element: %s", a.XMLName.Local)
+	}
+}
+
+func TestFDSNParams(t *testing.T) {
+	v, err := fdsnParams(url.Values{"start": {"2013-05-30"}, "minmag": {"3"}, "format": {"text"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Get("starttime") != "2013-05-30" || v.Get("minmagnitude") != "3" || v.Get("format") != "text" {
+		t.Errorf("abbreviations not expanded: %v", v)
+	}
+
+	if _, err := fdsnParams(url.Values{"start": {"2013-05-30"}, "starttime": {"2013-05-30"}}); err == nil {
+		t.Error("expected an error for both start and starttime")
+	}
+
+	// offset paging needs a total order.
+	for k, o := range fdsnOrder {
+		if !strings.HasSuffix(o, ", publicid") {
+			t.Errorf("orderby %s: expected publicid to break ties: %s", k, o)
+		}
+	}
+}
+
+func TestFDSNText(t *testing.T) {
+	var b bytes.Buffer
+
+	fdsnText(&b, []quakeRow{{
+		publicID:      "2013p407387",
+		magnitudeType: "M",
+		agency:        "WEL(GNS_Primary)",
+		locality:      "15 km south-east of Oxford",
+		originTime:    time.Date(2013, 5, 30, 15, 15, 37, 812174000, time.UTC),
+		latitude:      -43.397461,
+		longitude:     172.28223,
+		depth:         20.1,
+		magnitude:     4.02,
+	}})
+
+	l := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	if len(l) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(l))
+	}
+
+	e := "2013p407387|2013-05-30T15:15:37.812174|-43.397461|172.28223|20.1|WEL(GNS_Primary)|GeoNet|GeoNet|2013p407387|M|4.02|WEL(GNS_Primary)|15 km south-east of Oxford"
+	if l[1] != e {
+		t.Errorf("incorrect text line:\n got %s\nwant %s", l[1], e)
+	}
+}
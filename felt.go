@@ -17,32 +17,23 @@ const (
 var feltDoc = apidoc.Endpoint{
 	Title:       "Felt",
 	Description: `Look up Felt Report information.`,
-	Queries: []*apidoc.Query{
-		feltD,
-	},
 }
 
 var feltD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Felt",
 	Description: "Look up Felt Report information about earthquakes",
+	Discussion: `<p>The response is the GeoJSON FeatureCollection of felt reports for the quake from the
+	<a href="http://felt.geonet.org.nz">GeoNet felt report service</a>, passed through unchanged.
+	The Feature properties are defined by the felt report service and are not checked by this API so they are
+	not listed here.  The response is not found (404) if the quake ID is not known or the felt report service has no reports for it.</p>`,
 	Example:     "/felt/report?publicID=2013p407387",
 	ExampleHost: exHost,
-	URI:         "/felt/report?publicID=(publicID)",
 	Required: map[string]template.HTML{
 		"publicID": `a valid quake ID e.g., <code>2014p715167</code>`,
 	},
-	Props: map[string]template.HTML{
-		"todo": `todo`,
-	},
 }
 
 func felt(w http.ResponseWriter, r *http.Request) {
-	if err := feltD.CheckParams(r.URL.Query()); err != nil {
-		web.BadRequest(w, r, err.Error())
-		return
-	}
-
 	publicID := r.URL.Query().Get("publicID")
 
 	var d string
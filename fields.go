@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPrecision is the largest number of decimal places that can be requested for coordinates.
+const maxPrecision = 10
+
+var fieldRe = regexp.MustCompile(`^[a-zA-Z]+$`)
+
+// /quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&fields=publicID,time,magnitude&precision=3
+
+var fieldsD = &apidoc.Query{
+	Accept:      web.V1GeoJSON,
+	Title:       "Sparse Fields",
+	Description: "Limit the properties and coordinate decimal places in GeoJSON responses.",
+	Discussion: `<p>The <code>fields</code> and <code>precision</code> query parameters can be added to the version 1 or 2
+	GeoJSON quake queries that return quakes, the region queries, and the volcanic alert level query to reduce the size of the response.
+	All other query parameters are the same as for the query.
+	Properties that are not listed in <code>fields</code> are not included in each Feature and the order of the properties is unchanged.
+	The names in <code>fields</code> must be properties for the query.  <code>fields</code> and <code>precision</code> can not be used
+	for CSV or QuakeML.</p>`,
+	Example:     "/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&fields=publicID,time,magnitude&precision=3",
+	ExampleHost: exHost,
+	URI:         "/quake?(query)&fields=(property,property,...)&precision=(n)",
+	Params: map[string]template.HTML{
+		"query": `the query parameters for any of the queries that can have fields and precision.`,
+	},
+	Optional: map[string]template.HTML{
+		`fields`:    `a comma separated list of the properties to include for each Feature e.g., <code>publicID,time,magnitude</code>.`,
+		`precision`: `the number of decimal places for coordinates.  Must be between <code>0</code> and <code>10</code>.`,
+	},
+}
+
+// featureProp is a Feature property and the SQL expression that selects it.  Only the properties in
+// the tables of featureProps are selected so the fields query parameter is never used in SQL.
+type featureProp struct {
+	name string
+	sql  string
+	time bool // sql is a timestamp.  It is formatted for the quake GeoJSON version.
+	v2   bool // only in version 2 of the quake GeoJSON.
+}
+
+// withProp returns a copy of props with the property that has the same name as p replaced by p.
+func withProp(props []featureProp, p featureProp) []featureProp {
+	c := make([]featureProp, len(props))
+
+	for i := range props {
+		c[i] = props[i]
+		if c[i].name == p.name {
+			c[i] = p
+		}
+	}
+
+	return c
+}
+
+// geoJSONFormat is how Features are selected as GeoJSON for a request; the quake GeoJSON version,
+// the properties to include, and the coordinate precision.
+type geoJSONFormat struct {
+	v2        bool
+	fields    []string // the properties to include.  nil includes all properties.
+	precision int      // the coordinate decimal places.  -1 for the database default.
+}
+
+// newGeoJSONFormat returns the geoJSONFormat for the negotiated media type and the fields and precision
+// query parameters in r.  fields and precision can only be used for GeoJSON.
+func newGeoJSONFormat(r *http.Request) (gf geoJSONFormat, err error) {
+	gf.precision = -1
+	gf.v2 = isQuakeV2(r)
+
+	v := r.URL.Query()
+
+	_, fields := v["fields"]
+	_, precision := v["precision"]
+
+	if !fields && !precision {
+		return
+	}
+
+	if mt := mediaTypeOf(r); mt != web.V1GeoJSON && mt != quakeV2GeoJSON {
+		err = fmt.Errorf("fields and precision can only be used for GeoJSON.")
+		return
+	}
+
+	if fields {
+		if gf.fields, err = parseFields(v.Get("fields")); err != nil {
+			return
+		}
+	}
+
+	if precision {
+		s := v.Get("precision")
+		gf.precision, err = strconv.Atoi(s)
+		if err != nil || gf.precision < 0 || gf.precision > maxPrecision {
+			err = fmt.Errorf("Invalid precision, must be between 0 and %d: %s", maxPrecision, s)
+			return
+		}
+	}
+
+	return
+}
+
+// parseFields parses the comma separated list of property names s.
+func parseFields(s string) ([]string, error) {
+	var f []string
+
+	for _, p := range strings.Split(s, ",") {
+		if !fieldRe.MatchString(p) {
+			return nil, fmt.Errorf("Invalid fields: %s", s)
+		}
+		f = append(f, p)
+	}
+
+	return f, nil
+}
+
+// checkFields returns an error if the fields query parameter in v has a name that is not
+// a Feature property in the docs d.
+func checkFields(d *apidoc.Query, v url.Values) error {
+	if _, ok := v["fields"]; !ok {
+		return nil
+	}
+
+	f, err := parseFields(v.Get("fields"))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range f {
+		if _, ok := d.Props[p]; !ok || contains(collectionMembers, p) {
+			return fmt.Errorf("Invalid fields, %s is not a property for this query.", p)
+		}
+	}
+
+	return nil
+}
+
+// withoutFields returns a copy of v without the fields and precision query parameters.
+func withoutFields(v url.Values) url.Values {
+	c := url.Values{}
+
+	for k, s := range v {
+		if k != "fields" && k != "precision" {
+			c[k] = s
+		}
+	}
+
+	return c
+}
+
+// properties returns an SQL expression for the JSON properties of a Feature.  The properties
+// are the props, in order, that are in the format.  Version 2 properties are only in version 2.
+func (gf geoJSONFormat) properties(props []featureProp) string {
+	var s []string
+
+	for _, p := range props {
+		if (p.v2 && !gf.v2) || (gf.fields != nil && !contains(gf.fields, p.name)) {
+			continue
+		}
+		e := p.sql
+		if p.time {
+			e = gf.time(p.sql)
+		}
+
+		s = append(s, e+` as "`+p.name+`"`)
+	}
+
+	if s == nil {
+		return `'{}'::json`
+	}
+
+	return `row_to_json((SELECT l FROM (SELECT
+                         		` + strings.Join(s, ",\n                         		") + `
+                         ) as l))`
+}
+
+// time returns an SQL expression that formats the timestamp t.  Version 1 times are UTC (Z) and version 2
+// times have the offset from UTC, which is always +00:00.
+func (gf geoJSONFormat) time(t string) string {
+	if gf.v2 {
+		return `to_char(` + t + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS') || '+00:00'`
+	}
+
+	return `to_char(` + t + `, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`
+}
+
+// geometry returns an SQL expression for the GeoJSON geometry of geom with the format precision.
+func (gf geoJSONFormat) geometry(geom string) string {
+	if gf.precision < 0 {
+		return `ST_AsGeoJSON(` + geom + `)::json`
+	}
+
+	return `ST_AsGeoJSON(` + geom + `, ` + strconv.Itoa(gf.precision) + `)::json`
+}
@@ -12,22 +12,15 @@ import (
 
 var impactDoc = apidoc.Endpoint{Title: "Impact",
 	Description: `Look up impact information`,
-	Queries: []*apidoc.Query{
-		// intensityReportedD,
-		// intensityReportedLatestD,
-		intensityMeasuredLatestD,
-	},
 }
 
 var zoomRe = regexp.MustCompile(`^(5|6)$`)
 
 var intensityMeasuredLatestD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Measured Intensity - Latest",
 	Description: "Retrieve measured intensity information in the last sixty minutes.",
 	Example:     "/intensity?type=measured",
 	ExampleHost: exHost,
-	URI:         "/intensity?type",
 	Required: map[string]template.HTML{
 		"type": `<code>measured</code> is the only allowed value.`,
 	},
@@ -37,11 +30,6 @@ var intensityMeasuredLatestD = &apidoc.Query{
 }
 
 func intensityMeasuredLatest(w http.ResponseWriter, r *http.Request) {
-	if err := intensityMeasuredLatestD.CheckParams(r.URL.Query()); err != nil {
-		web.BadRequest(w, r, err.Error())
-		return
-	}
-
 	if r.URL.Query().Get("type") != "measured" {
 		web.BadRequest(w, r, "type must be measured.")
 		return
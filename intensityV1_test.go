@@ -0,0 +1,118 @@
+//# Impact
+//
+//##/intensity
+//
+// Look up impact information.
+//
+package main
+
+import (
+	"encoding/json"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/webtest"
+	"testing"
+)
+
+//## Predicted Intensity
+//
+// **GET /intensity/predict?lat=(lat)&lon=(lon)&depth=(depth)&magnitude=(magnitude)**
+//
+// Get the predicted intensity for a scenario quake.  The response is a FeatureCollection of MultiPolygons, one for each MMI band
+// with a predicted MMI of at least 3.  The `localities` member is an array of locality Features with the predicted intensity at each town and city.
+//
+//### Parameters
+//
+// * `lat` - the latitude of the scenario quake e.g., `-43.53`.
+// * `lon` - the longitude of the scenario quake e.g., `172.63`.  Longitudes from 180 to 360 are also accepted.
+// * `depth` - the depth (km) of the scenario quake e.g., `10`.  Must be between `0` and `700`.
+// * `magnitude` - the magnitude of the scenario quake e.g., `7`.  Must be between `0` and `10`.
+//
+//### Band Properties
+//
+// * `mmi` - the lower bound of the Modified Mercalli Intensity band e.g., `4`.
+// * `intensity` - the intensity for the band e.g., `light`.
+// * `radius` - the distance (km) from the epicenter to the outer edge of the band.
+//
+//### Example request:
+//
+// `/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7`
+//
+func TestIntensityPredictV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Type     string
+		Features []struct {
+			Geometry struct {
+				Type string
+			}
+			Properties struct {
+				MMI, Radius float64
+				Intensity   string
+			}
+		}
+		Localities []struct {
+			Properties struct {
+				Name string
+				MMI  float64
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Type != "FeatureCollection" {
+		t.Errorf("wrong type %s", f.Type)
+	}
+
+	if len(f.Features) < 2 {
+		t.Fatalf("expected several MMI bands got %d", len(f.Features))
+	}
+
+	for i, v := range f.Features {
+		if v.Geometry.Type != "MultiPolygon" {
+			t.Errorf("wrong geometry type %s", v.Geometry.Type)
+		}
+
+		if v.Properties.MMI < 3 || v.Properties.Intensity == "" {
+			t.Errorf("incorrect band %f %s", v.Properties.MMI, v.Properties.Intensity)
+		}
+
+		// bands are ordered by mmi so get smaller.
+		if i > 0 && (v.Properties.MMI <= f.Features[i-1].Properties.MMI || v.Properties.Radius >= f.Features[i-1].Properties.Radius) {
+			t.Error("bands not ordered by mmi")
+		}
+	}
+
+	if len(f.Localities) == 0 {
+		t.Fatal("expected some localities")
+	}
+
+	// the scenario quake is under Christchurch.
+	var found bool
+	for _, l := range f.Localities {
+		if l.Properties.Name == "Christchurch" {
+			found = true
+			if l.Properties.MMI < 7 {
+				t.Errorf("expected severe intensity in Christchurch got %f", l.Properties.MMI)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("expected Christchurch in the localities")
+	}
+}
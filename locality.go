@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// /quake/2013p407387/localities
+
+var quakeLocalitiesD = &apidoc.Query{
+	Title:       "Intensity at Localities",
+	Description: "the predicted intensity for a quake at each locality, ordered by MMI (highest first).",
+	Discussion: `<p>Localities are towns and cities (size 0 to 2).  The intensity is calculated using the same
+	<a href="https://github.com/GeoNet/quakes/issues/159">attenuation</a> as <code>regionIntensity</code>.
+	If the quake depth or magnitude is not known then there are no localities in the response.</p>`,
+	Example:     "/quake/2013p407387/localities",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		`publicID`: `a valid quake ID e.g., <code>2013p407387</code>.`,
+	},
+	Props: map[string]template.HTML{
+		`name`:      `the locality name.`,
+		`size`:      `the locality size; <code>0</code> (largest) to <code>2</code>.`,
+		`distance`:  `the distance (km) from the quake epicenter to the locality.`,
+		`bearing`:   `the direction of the quake from the locality e.g., <code>south-east</code>.`,
+		`mmi`:       `the predicted <a href="http://info.geonet.org.nz/x/w4IO">Modified Mercalli Intensity (MMI)</a> at the locality.`,
+		`intensity`: `the predicted <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> at the locality e.g., <code>weak</code>.`,
+	},
+}
+
+func quakeLocalities(w http.ResponseWriter, r *http.Request) {
+	publicID := strings.TrimSuffix(r.URL.Path[quakeLen:], "/localities")
+
+	var depth, magnitude float64
+
+	err := db.QueryRow("select depth, magnitude FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&depth, &magnitude)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// -9.0 is the unknown value for depth and magnitude.
+	if depth == -9.0 || magnitude == -9.0 {
+		b := []byte(`{"type":"FeatureCollection","features":[]}`)
+		web.Ok(w, r, &b)
+		return
+	}
+
+	// Minimum depth to avoid numeric instability.  Matches qrt.mmi_in_region.
+	if depth < 5.0 {
+		depth = 5.0
+	}
+
+	var d string
+
+	err = db.QueryRow(
+		`SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
+                         FROM (`+localitySQL("(SELECT origin_geom FROM qrt.quake_materialized WHERE publicid = $1)", "$2::numeric", "$3::numeric")+`) as f ) as fc`,
+		publicID, depth, magnitude).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
+
+// localitySQL returns an SQL query for locality Features with the predicted intensity for a quake at origin
+// with depth (km) and magnitude, ordered by MMI (highest first).  The arguments are SQL expressions.
+func localitySQL(origin, depth, magnitude string) string {
+	return `SELECT 'Feature' as type,
+                         ST_AsGeoJSON(l.locality_geom)::json as geometry,
+                         row_to_json((SELECT p FROM
+                         	(
+                         		SELECT
+                         		name,
+                         		size,
+                         		round(distance, 2) as distance,
+                         		bearing,
+                         		round(mmi, 2) as mmi,
+                         		qrt.mmi_to_intensity(mmi::double precision) as intensity
+                           ) as p
+                         )) as properties
+                         FROM (SELECT name, size, locality_geom, distance, bearing,
+                         	` + mmiSQL(depth, magnitude, "distance") + ` as mmi
+                         	FROM (SELECT name, size, locality_geom,
+                         		(ST_Distance_Sphere(` + origin + `, locality_geom) / 1000)::numeric as distance,
+                         		qrt.compass_azimuth(ST_Azimuth(locality_geom, ST_Shift_Longitude(` + origin + `))/(2*pi())*360) as bearing
+                         		FROM qrt.locality
+                         		WHERE size IN (0,1,2)) as d
+                         ) as l ORDER BY mmi DESC, name`
+}
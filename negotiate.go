@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mediaType is a media type e.g., application/vnd.geo+json;version=1
+type mediaType struct {
+	typ, subtype string
+	params       map[string]string // names are lower case.
+}
+
+// mediaRange is a media range from an Accept header e.g., application/vnd.geo+json;version=1;q=0.5
+// The type and subtype may be *.
+type mediaRange struct {
+	mediaType
+	q float64
+}
+
+// negotiate returns the media type in available that is most acceptable for the Accept header accept
+// using the rules in RFC 7231 section 5.3.2.  The quality for each media type is from the most specific
+// range that matches it.  Media types with the same quality are preferred in the order they are in available.
+// A missing or empty Accept header accepts any media type.  Returns false if none of available are acceptable.
+func negotiate(accept string, available []string) (string, bool) {
+	if len(available) == 0 {
+		return "", false
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return available[0], true
+	}
+
+	ranges := parseAccept(accept)
+
+	var best string
+	var bestQ float64
+
+	for _, a := range available {
+		if q := parseMediaType(a).quality(ranges); q > bestQ {
+			best, bestQ = a, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// parseAccept returns the media ranges in the Accept header accept.  Ranges that can't be parsed are ignored.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+
+next:
+	for _, s := range strings.Split(accept, ",") {
+		t, params := splitParams(s)
+
+		i := strings.Index(t, "/")
+		if i <= 0 || i == len(t)-1 || (t[:i] == "*" && t[i+1:] != "*") {
+			continue
+		}
+
+		m := mediaRange{
+			mediaType: mediaType{typ: t[:i], subtype: t[i+1:], params: make(map[string]string)},
+			q:         1.0,
+		}
+
+		// parameters after q are accept extensions and are ignored.
+		for _, p := range params {
+			if p[0] == "q" {
+				q, err := strconv.ParseFloat(p[1], 64)
+				if err != nil || q < 0 || q > 1 {
+					continue next
+				}
+				m.q = q
+				break
+			}
+			m.params[p[0]] = p[1]
+		}
+
+		ranges = append(ranges, m)
+	}
+
+	return ranges
+}
+
+// parseMediaType parses a media type e.g., application/vnd.geo+json;version=1
+func parseMediaType(s string) mediaType {
+	t, params := splitParams(s)
+
+	m := mediaType{params: make(map[string]string)}
+
+	if i := strings.Index(t, "/"); i > 0 {
+		m.typ, m.subtype = t[:i], t[i+1:]
+	}
+
+	for _, p := range params {
+		m.params[p[0]] = p[1]
+	}
+
+	return m
+}
+
+// splitParams returns the lower cased type/subtype and the parameter name value pairs
+// from a media type or range.  Parameter names are lower cased and quotes are removed from values.
+func splitParams(s string) (string, [][2]string) {
+	parts := strings.Split(s, ";")
+
+	var params [][2]string
+
+	for _, p := range parts[1:] {
+		i := strings.Index(p, "=")
+		if i <= 0 {
+			continue
+		}
+
+		params = append(params, [2]string{
+			strings.ToLower(strings.TrimSpace(p[:i])),
+			strings.Trim(strings.TrimSpace(p[i+1:]), `"`),
+		})
+	}
+
+	return strings.ToLower(strings.TrimSpace(parts[0])), params
+}
+
+// quality returns the quality of m from the most specific range in ranges that matches it.
+// Returns 0 if no range matches m.
+func (m mediaType) quality(ranges []mediaRange) float64 {
+	specificity := -1
+	var q float64
+
+	for _, r := range ranges {
+		s, ok := r.matches(m)
+		if ok && s > specificity {
+			specificity, q = s, r.q
+		}
+	}
+
+	return q
+}
+
+// matches returns true and the specificity of the match if r matches the media type m.
+// Every parameter in r must be in m with the same value.  More specific ranges have a higher specificity.
+func (r mediaRange) matches(m mediaType) (int, bool) {
+	switch {
+	case r.typ == "*":
+		return 0, true
+	case r.typ != m.typ:
+		return 0, false
+	case r.subtype == "*":
+		return 1, true
+	case r.subtype != m.subtype:
+		return 0, false
+	}
+
+	for k, v := range r.params {
+		if m.params[k] != v {
+			return 0, false
+		}
+	}
+
+	return 2 + len(r.params), true
+}
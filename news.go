@@ -19,9 +19,6 @@ const (
 var newsDoc = apidoc.Endpoint{
 	Title:       "News",
 	Description: `GeoNet news stories.`,
-	Queries: []*apidoc.Query{
-		newsD,
-	},
 }
 
 // Feed is used for unmarshaling XML (from the GeoNet RSS news feed)
@@ -66,15 +63,10 @@ func unmarshalNews(b []byte) (f Feed, err error) {
 // /news/geonet
 
 var newsD = &apidoc.Query{
-	Accept:      web.V1JSON,
 	Title:       "News",
 	Description: " Returns a simple JSON version of the GeoNet News RSS feed.",
 	Example:     "/news/geonet",
 	ExampleHost: exHost,
-	URI:         "/news/geonet",
-	Params: map[string]template.HTML{
-		"none": `no query parameters are required.`,
-	},
 	Props: map[string]template.HTML{
 		"mlink":     "a link to a mobile version of the news story.",
 		"link":      "a link to the news story.",
@@ -84,11 +76,6 @@ var newsD = &apidoc.Query{
 }
 
 func news(w http.ResponseWriter, r *http.Request) {
-	if len(r.URL.Query()) != 0 {
-		web.BadRequest(w, r, "incorrect number of query parameters.")
-		return
-	}
-
 	j, err := fetchRSS(newsURL)
 	if err != nil {
 		web.ServiceUnavailable(w, r, err)
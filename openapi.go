@@ -0,0 +1,688 @@
+package main
+
+import (
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openAPIPath is the path for the OpenAPI specification.
+const openAPIPath = apidoc.Path + "/openapi.json"
+
+// bodySchemas are the schemas for the POST request body Content-Types.
+var bodySchemas = map[string]*openAPISchema{
+	polygonContent: {
+		Type:        "object",
+		Description: "a GeoJSON Polygon or MultiPolygon geometry, or a Feature with one of those geometries.",
+	},
+	publicIDsContent: {
+		Type:     "object",
+		Required: []string{"publicID"},
+		Properties: map[string]*openAPISchema{
+			"publicID": {
+				Type:     "array",
+				MaxItems: maxPublicIDs,
+				Items:    &openAPISchema{Type: "string", Pattern: publicIDRe.String()},
+			},
+		},
+	},
+}
+
+// collectionMembers are documented with the Props for a query but are members of the
+// GeoJSON FeatureCollection, not Feature properties.
+var collectionMembers = []string{"next", "notFound", "summary", "localities", "deleted"}
+
+// openAPI is an OpenAPI 3 specification.  Only the parts of the specification that are needed
+// to describe this API are included.  See https://spec.openapis.org/oas/v3.0.3
+type openAPI struct {
+	OpenAPI string                                 `json:"openapi"`
+	Info    openAPIInfo                            `json:"info"`
+	Servers []openAPIServer                        `json:"servers,omitempty"`
+	Tags    []openAPITag                           `json:"tags,omitempty"`
+	Paths   map[string]map[string]openAPIOperation `json:"paths"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Queries     []openAPIQuery             `json:"x-queries,omitempty"`
+}
+
+// openAPIQuery is one of the queries for an operation with several queries e.g., GET /quake.  OpenAPI can only
+// describe parameters for the whole operation so the parameters for each query are in the x-queries extension.
+type openAPIQuery struct {
+	Summary     string   `json:"summary"`
+	Example     string   `json:"example,omitempty"`
+	ContentType []string `json:"contentType,omitempty"` // the request body Content-Types that select the query.
+	Required    []string `json:"required,omitempty"`
+	Optional    []string `json:"optional,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required"`
+	Style       string         `json:"style,omitempty"`
+	Explode     *bool          `json:"explode,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Type        string                    `json:"type,omitempty"`
+	Format      string                    `json:"format,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Enum        []string                  `json:"enum,omitempty"`
+	Pattern     string                    `json:"pattern,omitempty"`
+	Nullable    bool                      `json:"nullable,omitempty"`
+	MinItems    int                       `json:"minItems,omitempty"`
+	MaxItems    int                       `json:"maxItems,omitempty"`
+	Required    []string                  `json:"required,omitempty"`
+	Items       *openAPISchema            `json:"items,omitempty"`
+	Properties  map[string]*openAPISchema `json:"properties,omitempty"`
+	OneOf       []*openAPISchema          `json:"oneOf,omitempty"`
+}
+
+// openAPIJSON is the OpenAPI specification.  It is made from the route tables in init.
+var openAPIJSON []byte
+
+// openAPIDocs serves the OpenAPI specification for the API.
+func openAPIDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Surrogate-Control", web.MaxAge300)
+
+	b := openAPIJSON
+	web.Ok(w, r, &b)
+}
+
+// openAPISpec returns the OpenAPI specification generated from the route tables and the API docs.
+// Routes with the same path e.g., the /quake queries, are one operation because OpenAPI
+// doesn't distinguish operations by query parameter.  The parameters for each query are in x-queries (see openAPIQuery).
+func openAPISpec() openAPI {
+	s := openAPI{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:       docs.Title,
+			Description: strings.TrimSpace(string(docs.Description)),
+			Version:     "1",
+		},
+		Paths: make(map[string]map[string]openAPIOperation),
+	}
+
+	if docs.APIHost != "" {
+		s.Servers = append(s.Servers, openAPIServer{URL: "http://" + docs.APIHost})
+	}
+
+	tags := make(map[string]bool)
+
+	for _, m := range []struct {
+		method string
+		routes []route
+	}{
+		{"get", getRoutes},
+		{"post", postRoutes},
+	} {
+		var paths []string
+		byPath := make(map[string][]route)
+
+		for _, rt := range m.routes {
+			if rt.doc == nil {
+				continue
+			}
+
+			p := openAPIPathOf(rt.path)
+			if _, ok := byPath[p]; !ok {
+				paths = append(paths, p)
+			}
+			byPath[p] = append(byPath[p], rt)
+		}
+
+		for _, p := range paths {
+			routes := byPath[p]
+
+			var fixed map[string]string
+			if len(routes) == 1 {
+				fixed = routes[0].fixed()
+			}
+
+			s.add(p, m.method, newOperation(m.method, p, routes, fixed))
+			tags[routes[0].endpoint] = true
+		}
+	}
+
+	for _, e := range endpoints {
+		if tags[e.path] {
+			s.Tags = append(s.Tags, openAPITag{Name: e.path, Description: e.endpoint.Title + ".  " + string(e.endpoint.Description)})
+		}
+	}
+
+	return s
+}
+
+// add adds the operation o for method to the path p.
+func (s *openAPI) add(p, method string, o openAPIOperation) {
+	if _, ok := s.Paths[p]; !ok {
+		s.Paths[p] = make(map[string]openAPIOperation)
+	}
+
+	s.Paths[p][method] = o
+}
+
+// fixed returns the query parameter that has a fixed value for the route e.g., type=measured.
+func (rt route) fixed() map[string]string {
+	i := strings.Index(rt.query, "=")
+	if i <= 0 {
+		return nil
+	}
+
+	return map[string]string{rt.query[:i]: rt.query[i+1:]}
+}
+
+// openAPIPathOf returns the route path p as an OpenAPI path template e.g., /quake/{publicID}
+func openAPIPathOf(p string) string {
+	return strings.Replace(strings.Replace(p, "(", "{", -1), ")", "}", -1)
+}
+
+// operationID returns an id for the operation e.g., getQuakeByPublicIDSequence
+func operationID(method, p string) string {
+	id := method
+
+	for _, s := range strings.Split(p, "/") {
+		switch {
+		case s == "":
+		case strings.HasPrefix(s, "{"):
+			s = strings.Trim(s, "{}")
+			id += "By" + strings.ToUpper(s[:1]) + s[1:]
+		default:
+			id += strings.ToUpper(s[:1]) + s[1:]
+		}
+	}
+
+	return id
+}
+
+// newOperation returns the operation for the routes on the path p.  A parameter is required if it is required for
+// all of the routes.  The parameters for each route are in x-queries when there is more than one route.  fixed are
+// query parameters with a single valid value.
+func newOperation(method, p string, routes []route, fixed map[string]string) openAPIOperation {
+	rt := routes[0]
+
+	o := openAPIOperation{
+		OperationID: operationID(method, p),
+		Tags:        []string{rt.endpoint},
+		Responses: map[string]openAPIResponse{
+			"400": {Description: "Bad Request.  The query parameters are not valid.", Content: errorContent()},
+			"406": {Description: "Not Acceptable.  The query is not available as any of the media types in the Accept header.", Content: errorContent()},
+		},
+	}
+
+	var q []*apidoc.Query
+	for _, r := range routes {
+		q = append(q, r.doc)
+	}
+
+	var titles []string
+	var props []map[string]template.HTML
+
+	for _, d := range q {
+		titles = append(titles, d.Title)
+		props = append(props, d.Props)
+	}
+
+	o.Summary = strings.Join(titles, "; ")
+
+	if len(q) == 1 {
+		o.Description = fmt.Sprintf("<p>%s</p>%s", q[0].Description, q[0].Discussion)
+	} else {
+		for _, d := range q {
+			o.Description += fmt.Sprintf("<h4>%s</h4><p><code>%s</code></p><p>%s</p>%s", d.Title, d.URI, d.Description, d.Discussion)
+		}
+	}
+
+	// parameters from all of q.  The description is from the first query that has the parameter.
+	in := make(map[string]string)
+	desc := make(map[string]template.HTML)
+	count := make(map[string]int)
+
+	for _, d := range q {
+		for k, v := range d.Params {
+			if strings.Contains(p, "{"+k+"}") {
+				in[k], count[k] = "path", count[k]+1
+				if _, ok := desc[k]; !ok {
+					desc[k] = v
+				}
+			}
+		}
+
+		for _, m := range []map[string]template.HTML{d.Required, d.Optional} {
+			for k, v := range m {
+				in[k] = "query"
+				if _, ok := desc[k]; !ok {
+					desc[k] = v
+				}
+			}
+		}
+
+		for k := range d.Required {
+			count[k]++
+		}
+	}
+
+	if rt.fields {
+		for k, v := range fieldsD.Optional {
+			if _, ok := in[k]; !ok {
+				in[k], desc[k] = "query", v
+			}
+		}
+	}
+
+	var names []string
+	for k := range in {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		o.Parameters = append(o.Parameters, newParameter(k, in[k], string(desc[k]), count[k] == len(q), fixed[k]))
+	}
+
+	if len(routes) > 1 {
+		for _, r := range routes {
+			o.Queries = append(o.Queries, newQuery(r))
+		}
+	}
+
+	for _, r := range routes {
+		for _, c := range r.content {
+			if b, ok := bodySchemas[c]; ok {
+				if o.RequestBody == nil {
+					o.RequestBody = &openAPIRequestBody{Required: true, Content: make(map[string]openAPIMediaType)}
+				}
+				o.RequestBody.Content[c] = openAPIMediaType{Schema: b}
+			}
+		}
+	}
+
+	var pathParam bool
+	for _, v := range in {
+		pathParam = pathParam || v == "path"
+	}
+
+	if pathParam {
+		o.Responses["404"] = openAPIResponse{Description: "Not Found.", Content: errorContent()}
+	}
+
+	ok := openAPIResponse{Description: "OK.", Content: make(map[string]openAPIMediaType)}
+	p200 := mergeHTML(props...)
+	types := queryTypesOf(q)
+	g := geometryOf(q)
+
+	for _, mt := range rt.accept {
+		switch mt {
+		case web.V1GeoJSON:
+			ok.Content[mt] = openAPIMediaType{Schema: featureCollectionSchema(withoutV2(p200), types, g, false)}
+		case quakeV2GeoJSON:
+			ok.Content[mt] = openAPIMediaType{Schema: featureCollectionSchema(p200, types, g, true)}
+		case web.V1JSON:
+			ok.Content[mt] = openAPIMediaType{Schema: feedSchema(p200)}
+		default:
+			ok.Content[mt] = openAPIMediaType{Schema: &openAPISchema{Type: "string"}}
+		}
+	}
+
+	o.Responses["200"] = ok
+
+	return o
+}
+
+// newQuery returns the x-queries entry for the route rt.  Path parameters are not included.
+func newQuery(rt route) openAPIQuery {
+	o := openAPIQuery{
+		Summary:     rt.doc.Title,
+		Example:     rt.doc.Example,
+		ContentType: rt.content,
+	}
+
+	for k := range rt.doc.Required {
+		o.Required = append(o.Required, k)
+	}
+
+	for k := range rt.doc.Optional {
+		o.Optional = append(o.Optional, k)
+	}
+
+	if rt.fields {
+		for k := range fieldsD.Optional {
+			o.Optional = append(o.Optional, k)
+		}
+	}
+
+	sort.Strings(o.Required)
+	sort.Strings(o.Optional)
+
+	return o
+}
+
+// newParameter returns the parameter name.  The schema is from the params for the route parameters.
+// If value is not empty then it is the only valid value.
+func newParameter(name, in, desc string, required bool, value string) openAPIParameter {
+	o := openAPIParameter{
+		Name:        name,
+		In:          in,
+		Description: desc,
+		Required:    required || in == "path",
+		Schema:      &openAPISchema{Type: "string"},
+	}
+
+	c, ok := queryParams[name]
+	if in == "path" {
+		c, ok = pathParams[name]
+	}
+
+	switch {
+	case value != "":
+		o.Schema.Enum = []string{value}
+	case ok:
+		o.Schema.Enum = c.values
+		if c.values == nil {
+			o.Schema.Pattern = c.re.String()
+		}
+
+		if c.list {
+			explode := false
+			o.Style, o.Explode = "form", &explode
+			o.Schema = &openAPISchema{Type: "array", Items: o.Schema}
+		}
+	}
+
+	return o
+}
+
+// dateTime is the schema for times.  Version 1 times are UTC and version 2 times have the offset from UTC.
+var dateTime = openAPISchema{Type: "string", Format: "date-time"}
+
+// propTypes are the schemas for the properties, and FeatureCollection members, that are not strings.
+// The intensity and quality enums are the same as for the query parameters with the same name.
+var propTypes = map[string]openAPISchema{
+	"time":                  dateTime,
+	"modificationTime":      dateTime,
+	"depth":                 {Type: "number", Nullable: true},
+	"magnitude":             {Type: "number", Nullable: true},
+	"distance":              {Type: "number"},
+	"slantDistance":         {Type: "number"},
+	"radius":                {Type: "number"},
+	"mmi":                   {Type: "number"},
+	"max_mmi":               {Type: "number"},
+	"min_mmi":               {Type: "number"},
+	"usedPhaseCount":        {Type: "integer"},
+	"magnitudeStationCount": {Type: "integer"},
+	"count":                 {Type: "integer"},
+	"size":                  {Type: "integer"},
+	"level":                 {Type: "integer"},
+	"intensity":             {Type: "string", Enum: queryParams["intensity"].values},
+	"regionIntensity":       {Type: "string", Enum: queryParams["regionIntensity"].values},
+	// quakes that are not good enough to be caution have the quality unknown.  It can't be used as a query parameter.
+	"quality":  {Type: "string", Enum: append(append([]string{}, queryParams["quality"].values...), "unknown")},
+	"next":     {Type: "string", Nullable: true},
+	"notFound": {Type: "array", Items: &openAPISchema{Type: "string", Pattern: publicIDRe.String()}},
+	"deleted": {Type: "array", Items: &openAPISchema{
+		Type:     "object",
+		Required: []string{"publicID", "time"},
+		Properties: map[string]*openAPISchema{
+			"publicID": {Type: "string", Pattern: publicIDRe.String()},
+			"time":     &dateTime,
+		},
+	}},
+	"summary": {Type: "object", Properties: map[string]*openAPISchema{
+		"mainshock": sequenceQuakeSchema(false),
+		"largest":   sequenceQuakeSchema(true),
+		"window": {Type: "object", Properties: map[string]*openAPISchema{
+			"radius":  {Type: "number"},
+			"days":    {Type: "number"},
+			"endTime": &dateTime,
+		}},
+		"count": {Type: "integer"},
+		"dailyRate": {Type: "array", Items: &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{
+			"date":  {Type: "string", Format: "date"},
+			"count": {Type: "integer"},
+		}}},
+	}},
+}
+
+// queryTypes are the schemas for properties that are different for a query than in propTypes.
+var queryTypes = map[*apidoc.Query]map[string]openAPISchema{
+	quakeChangesD: {"next": dateTime},
+}
+
+// geometryTypes are the GeoJSON geometry types for queries that don't have Point geometries.  An empty
+// type is any geometry.
+var geometryTypes = map[*apidoc.Query]string{
+	regionD:           "Polygon",
+	regionsD:          "Polygon",
+	quakeRegionsD:     "",
+	quakeContoursD:    "MultiPolygon",
+	intensityPredictD: "MultiPolygon",
+	feltD:             "",
+}
+
+// sequenceQuakeSchema returns the schema for a quake in the sequence summary.
+func sequenceQuakeSchema(nullable bool) *openAPISchema {
+	return &openAPISchema{
+		Type:     "object",
+		Nullable: nullable,
+		Properties: map[string]*openAPISchema{
+			"publicID":  {Type: "string", Pattern: publicIDRe.String()},
+			"time":      &dateTime,
+			"magnitude": {Type: "number"},
+		},
+	}
+}
+
+// queryTypesOf returns the schemas from queryTypes for the queries q.
+func queryTypesOf(q []*apidoc.Query) map[string]openAPISchema {
+	t := make(map[string]openAPISchema)
+
+	for _, d := range q {
+		for k, v := range queryTypes[d] {
+			t[k] = v
+		}
+	}
+
+	return t
+}
+
+// geometryOf returns the geometry type for the queries q.  Any geometry if they are not all the same.
+func geometryOf(q []*apidoc.Query) string {
+	var g []string
+
+	for _, d := range q {
+		t, ok := geometryTypes[d]
+		if !ok {
+			t = "Point"
+		}
+
+		if len(g) > 0 && g[0] != t {
+			return ""
+		}
+
+		g = append(g, t)
+	}
+
+	if len(g) == 0 {
+		return ""
+	}
+
+	return g[0]
+}
+
+// propSchema returns the schema for the property or FeatureCollection member name.  The schema is from
+// types, then propTypes, and is a string if it isn't in either.
+func propSchema(name string, desc template.HTML, types map[string]openAPISchema) *openAPISchema {
+	s, ok := types[name]
+	if !ok {
+		if s, ok = propTypes[name]; !ok {
+			s = openAPISchema{Type: "string"}
+		}
+	}
+
+	s.Description = string(desc)
+
+	return &s
+}
+
+// geometrySchema returns the schema for a GeoJSON geometry of type t or any geometry if t is empty.  Version 2 quake
+// GeoJSON Points have the depth (km) as the third coordinate.
+func geometrySchema(t string, v2 bool) *openAPISchema {
+	g := &openAPISchema{
+		Type:        "object",
+		Nullable:    true,
+		Description: "a GeoJSON geometry.  Coordinates are longitude, latitude (WGS84).",
+		Required:    []string{"type", "coordinates"},
+		Properties: map[string]*openAPISchema{
+			"type":        {Type: "string"},
+			"coordinates": {Type: "array", Items: &openAPISchema{}},
+		},
+	}
+
+	if t == "" {
+		return g
+	}
+
+	g.Properties["type"].Enum = []string{t}
+
+	c := &openAPISchema{Type: "array", MinItems: 2, MaxItems: 2, Items: &openAPISchema{Type: "number"}}
+	if v2 {
+		c.MinItems, c.MaxItems = 3, 3
+	}
+
+	switch t {
+	case "Polygon":
+		c = &openAPISchema{Type: "array", Items: &openAPISchema{Type: "array", Items: c}}
+	case "MultiPolygon":
+		c = &openAPISchema{Type: "array", Items: &openAPISchema{Type: "array", Items: &openAPISchema{Type: "array", Items: c}}}
+	}
+
+	g.Properties["coordinates"] = c
+
+	return g
+}
+
+// featureSchema returns the schema for a GeoJSON Feature with props and a geometry of type g.
+// Version 2 quake GeoJSON Features also have an id.
+func featureSchema(props map[string]template.HTML, types map[string]openAPISchema, g string, v2 bool) *openAPISchema {
+	fp := make(map[string]*openAPISchema)
+
+	for k, v := range props {
+		fp[k] = propSchema(k, v, types)
+	}
+
+	f := map[string]*openAPISchema{
+		"type":       {Type: "string", Enum: []string{"Feature"}},
+		"geometry":   geometrySchema(g, v2),
+		"properties": {Type: "object", Properties: fp},
+	}
+
+	if len(fp) == 0 {
+		f["properties"].Description = "the properties are not documented for this query.  See the query description."
+	}
+
+	if v2 {
+		f["id"] = &openAPISchema{Type: "string", Description: "the quake publicID."}
+	}
+
+	return &openAPISchema{Type: "object", Required: []string{"type", "geometry", "properties"}, Properties: f}
+}
+
+// featureCollectionSchema returns the schema for a GeoJSON FeatureCollection with Features that have props and a geometry
+// of type g.  Version 2 quake GeoJSON also has a bbox.
+func featureCollectionSchema(props map[string]template.HTML, types map[string]openAPISchema, g string, v2 bool) *openAPISchema {
+	fp := make(map[string]template.HTML)
+	fc := map[string]*openAPISchema{
+		"type": {Type: "string", Enum: []string{"FeatureCollection"}},
+	}
+
+	for k, v := range props {
+		switch {
+		case k == "localities":
+			fc[k] = &openAPISchema{Type: "array", Description: string(v), Items: featureSchema(quakeLocalitiesD.Props, types, "Point", false)}
+		case contains(collectionMembers, k):
+			fc[k] = propSchema(k, v, types)
+		default:
+			fp[k] = v
+		}
+	}
+
+	if v2 {
+		fc["bbox"] = &openAPISchema{
+			Type:        "array",
+			Description: "the extent of the Features; min longitude, latitude, depth and then max longitude, latitude, depth.",
+			MinItems:    6,
+			MaxItems:    6,
+			Items:       &openAPISchema{Type: "number"},
+		}
+	}
+
+	fc["features"] = &openAPISchema{Type: "array", Items: featureSchema(fp, types, g, v2)}
+
+	return &openAPISchema{Type: "object", Required: []string{"type", "features"}, Properties: fc}
+}
+
+// feedSchema returns the schema for the JSON queries.  They are RSS feeds as a Feed of entries that have props.
+func feedSchema(props map[string]template.HTML) *openAPISchema {
+	e := make(map[string]*openAPISchema)
+
+	for k, v := range props {
+		e[k] = propSchema(k, v, nil)
+	}
+
+	return &openAPISchema{
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"feed": {Type: "array", Items: &openAPISchema{Type: "object", Properties: e}},
+		},
+	}
+}
+
+// errorContent is the content for error responses.
+func errorContent() map[string]openAPIMediaType {
+	return map[string]openAPIMediaType{
+		"text/plain": {Schema: &openAPISchema{Type: "string"}},
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/GeoNet/web"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pageD documents the optional query parameters for paging through quake lists.
+var pageD = map[string]template.HTML{
+	`limit`: limitD,
+	`cursor`: `return the quakes after this cursor.  Use the value of <code>next</code> from the previous response, or follow the 
+	<code>Link</code> header with <code>rel="next"</code>.`,
+}
+
+// nextD documents the next member of paged quake lists.
+var nextD = map[string]template.HTML{
+	`next`: `the cursor for the next page of quakes or <code>null</code> if there are no more quakes.  This is a member of the 
+	FeatureCollection, not a quake property.  The URL for the next page is also in the <code>Link</code> header.`,
+}
+
+// quakeCursor is a position in a quake list ordered by origin time (most recent first).
+// publicID breaks ties between quakes with the same origin time.
+type quakeCursor struct {
+	time     time.Time
+	publicID string
+}
+
+// String returns the opaque cursor value for c.
+func (c quakeCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.time.UTC().Format(time.RFC3339Nano) + " " + c.publicID))
+}
+
+// parseCursor parses a cursor value made by quakeCursor.String.
+func parseCursor(s string) (c quakeCursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		err = fmt.Errorf("Invalid cursor: %s", s)
+		return
+	}
+
+	p := strings.Split(string(b), " ")
+	if len(p) != 2 || !publicIDRe.MatchString(p[1]) {
+		err = fmt.Errorf("Invalid cursor: %s", s)
+		return
+	}
+
+	if c.time, err = time.Parse(time.RFC3339Nano, p[0]); err != nil {
+		err = fmt.Errorf("Invalid cursor: %s", s)
+		return
+	}
+
+	c.publicID = p[1]
+
+	return
+}
+
+// addCursor adds a filter for quakes after cursor.
+func (f *quakeFilter) addCursor(cursor string) error {
+	c, err := parseCursor(cursor)
+	if err != nil {
+		return err
+	}
+
+	f.add("(q.origintime, q.publicid) < (?, ?)", c.time, c.publicID)
+
+	return nil
+}
+
+// writeQuakePage writes a page of the quakes selected by f using the limit and cursor parameters in v.
+// If there are more quakes then the Link header is set for the next page.
+func writeQuakePage(w http.ResponseWriter, r *http.Request, f *quakeFilter, v url.Values) {
+	limit, err := parseLimit(v.Get("limit"))
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if c := v.Get("cursor"); c != "" {
+		if err = f.addCursor(c); err != nil {
+			web.BadRequest(w, r, err.Error())
+			return
+		}
+	}
+
+	if f.format, err = newGeoJSONFormat(r); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	d, next, err := f.featurePage(limit)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// A Link for POST requests would have to be followed with the same body so only the next member is set.
+	if next != "" && r.Method != "POST" {
+		q := r.URL.Query()
+		q.Set("cursor", next)
+		w.Header().Set("Link", `<`+r.URL.Path+`?`+q.Encode()+`>; rel="next"`)
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
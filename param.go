@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// param declares the valid values for a path or query parameter.
+type param struct {
+	re     *regexp.Regexp // each value must match re.
+	list   bool           // the value is a comma separated list.
+	desc   string         // the valid values for error messages.  Optional.
+	values []string       // the valid values if there are a fixed number of them.  Optional.
+}
+
+// params are the params for the routes by name.  Parameters with the same name have the same
+// valid values in all routes.  Values for names that are not in params are checked by the route handler.
+type params map[string]param
+
+// pathParams are the params for the (name) segments in route paths.
+var pathParams = params{
+	"publicID": {re: publicIDRe},
+}
+
+// queryParams are the params for route query parameters.
+var queryParams = params{
+	"intensity":       enum(false, "unnoticeable", "weak", "light", "moderate", "strong", "severe"),
+	"regionIntensity": enum(false, "unnoticeable", "weak", "light", "moderate", "strong", "severe"),
+	"quality":         enum(true, "best", "caution", "deleted", "good"),
+	"type":            {re: typeRe, list: true},
+}
+
+// enum returns a param that must be one of values.  If list is true then the param
+// is a comma separated list of values.
+func enum(list bool, values ...string) param {
+	d := "one of " + strings.Join(values, ", ")
+	if list {
+		d = "a comma separated list of " + strings.Join(values, ", ")
+	}
+
+	return param{
+		re:     regexp.MustCompile(`^(` + strings.Join(values, "|") + `)$`),
+		list:   list,
+		desc:   d,
+		values: values,
+	}
+}
+
+// check returns an error if value is not valid for the param name.
+func (p params) check(name, value string) error {
+	c, ok := p[name]
+	if !ok {
+		return nil
+	}
+
+	v := []string{value}
+	if c.list {
+		v = strings.Split(value, ",")
+	}
+
+	for _, s := range v {
+		if !c.re.MatchString(s) {
+			if c.desc != "" {
+				return fmt.Errorf("invalid %s: %s.  Must be %s.", name, value, c.desc)
+			}
+			return fmt.Errorf("invalid %s: %s", name, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mmiSQL returns an SQL expression for the predicted MMI at distance (km) from the epicenter of a quake
+// with depth (km) and magnitude.  The arguments are SQL expressions.  The attenuation is the same as for
+// qrt.mmi_in_region (https://github.com/GeoNet/quakes/issues/159) and uses the slant distance from the hypocenter.
+// depth should be at least 5 km to avoid numeric instability.  -1 is used for no predicted intensity, as for qrt.maxmmi.
+func mmiSQL(depth, magnitude, distance string) string {
+	slant := "sqrt(" + distance + " * " + distance + " + " + depth + " * " + depth + ")"
+
+	return "GREATEST(qrt.maxmmi(" + depth + ", " + magnitude + ") - 1.18 * ln(" + slant + " / " + depth + ") - 0.0044 * (" + slant + " - " + depth + "), -1.0)"
+}
+
+// /quake/2013p407387/intensity?lat=-43.53&lon=172.63
+
+var quakeIntensityD = &apidoc.Query{
+	Title:       "Intensity at a Point",
+	Description: "the predicted intensity for a quake at a point.",
+	Discussion: `<p>The intensity is calculated using the same <a href="https://github.com/GeoNet/quakes/issues/159">attenuation</a>
+	as <code>regionIntensity</code> with the slant distance from the quake hypocenter.  The response is a FeatureCollection with
+	a single Feature for the point.  If the quake depth or magnitude is not known then the <code>mmi</code> is <code>-1</code>.</p>`,
+	Example:     "/quake/2013p407387/intensity?lat=-43.53&lon=172.63",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		`publicID`: `a valid quake ID e.g., <code>2013p407387</code>.`,
+	},
+	Required: map[string]template.HTML{
+		`lat`: `the latitude of the point e.g., <code>-43.53</code>.`,
+		`lon`: `the longitude of the point e.g., <code>172.63</code>.  Longitudes from 180 to 360 are also accepted.`,
+	},
+	Props: predictedD,
+}
+
+// predictedD documents the properties for predicted intensity at a point.
+var predictedD = map[string]template.HTML{
+	`mmi`:           `the predicted <a href="http://info.geonet.org.nz/x/w4IO">Modified Mercalli Intensity (MMI)</a> at the point.  <code>-1</code> if there is no predicted intensity.`,
+	`intensity`:     `the predicted <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> at the point e.g., <code>weak</code>.`,
+	`distance`:      `the distance (km) from the quake epicenter to the point.`,
+	`slantDistance`: `the distance (km) from the quake hypocenter to the point.`,
+}
+
+func quakeIntensity(w http.ResponseWriter, r *http.Request) {
+	publicID := strings.TrimSuffix(r.URL.Path[quakeLen:], "/intensity")
+
+	lat, lon, err := parsePoint(r.URL.Query().Get("lat"), r.URL.Query().Get("lon"))
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	var d string
+
+	// Check that the publicid exists in the DB.
+	err = db.QueryRow("select publicid FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&d)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// -9.0 is the unknown value for depth and magnitude.  The minimum depth of 5 km matches qrt.mmi_in_region.
+	err = db.QueryRow(
+		`SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type, array_to_json(array_agg(f)) as features
+                         FROM (SELECT 'Feature' as type,
+                         ST_AsGeoJSON(ST_SetSRID(ST_MakePoint($3, $2), 4326))::json as geometry,
+                         row_to_json((SELECT l FROM
+                         	(
+                         		SELECT
+                         		round(mmi, 2) as mmi,
+                         		qrt.mmi_to_intensity(mmi::double precision) as intensity,
+                         		round(distance, 2) as distance,
+                         		round(sqrt(distance * distance + depth * depth), 2) as "slantDistance"
+                           ) as l
+                         )) as properties
+                         FROM (SELECT distance, depth,
+                         	CASE WHEN depth = -9.0 OR magnitude = -9.0 THEN -1.0
+                         	ELSE `+mmiSQL("GREATEST(depth, 5.0)", "magnitude", "distance")+` END as mmi
+                         	FROM (SELECT (ST_Distance_Sphere(q.origin_geom, ST_SetSRID(ST_MakePoint($3, $2), 4326)) / 1000)::numeric as distance,
+                         		q.depth, q.magnitude
+                         		FROM qrt.quake_materialized as q WHERE q.publicid = $1) as d
+                         ) as p ) as f ) as fc`, publicID, lat, lon).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
+
+// parsePoint parses lat and lon for a point.  Longitudes from 180 to 360 are converted to -180 to 180.
+func parsePoint(lat, lon string) (la, lo float64, err error) {
+	la, err = strconv.ParseFloat(lat, 64)
+	if err != nil || la < -90 || la > 90 {
+		err = fmt.Errorf("Invalid lat, must be between -90 and 90: %s", lat)
+		return
+	}
+
+	lo, err = strconv.ParseFloat(lon, 64)
+	if err != nil || lo < -180 || lo > 360 {
+		err = fmt.Errorf("Invalid lon, must be between -180 and 360: %s", lon)
+		return
+	}
+
+	if lo > 180 {
+		lo -= 360
+	}
+
+	return
+}
+
+// contourSQL returns an SQL query for Features of the predicted MMI bands for a quake at origin with depth (km) and magnitude.
+// The arguments are SQL expressions.  The attenuation only depends on distance so each band is the area between two circles
+// around the epicenter.  The predicted MMI is evaluated every km out to 2000 km.
+func contourSQL(origin, depth, magnitude string) string {
+	return `SELECT 'Feature' as type,
+                         ST_AsGeoJSON(ST_Multi(CASE WHEN inner_radius IS NULL THEN disk
+                         	ELSE ST_Difference(disk, ST_Buffer(` + origin + `::geography, inner_radius * 1000)::geometry) END), 4)::json as geometry,
+                         row_to_json((SELECT p FROM
+                         	(
+                         		SELECT
+                         		mmi,
+                         		qrt.mmi_to_intensity(mmi::double precision) as intensity,
+                         		radius
+                           ) as p
+                         )) as properties
+                         FROM (SELECT mmi, radius, inner_radius, ST_Buffer(` + origin + `::geography, radius * 1000)::geometry as disk
+                         	FROM (SELECT k as mmi, max(d) as radius, lead(max(d)) OVER (ORDER BY k) as inner_radius
+                         		FROM generate_series(3, 12) as k,
+                         		(SELECT d, ` + mmiSQL(depth, magnitude, "d::numeric") + ` as mmi FROM generate_series(0, 2000) as d) as m
+                         		WHERE m.mmi >= k GROUP BY k) as r
+                         	WHERE radius > 0
+                         ) as c ORDER BY mmi`
+}
+
+// /intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7
+
+var intensityPredictD = &apidoc.Query{
+	Title:       "Predicted Intensity",
+	Description: "the predicted intensity for a scenario quake.",
+	Discussion: `<p>The intensity is calculated using the same <a href="https://github.com/GeoNet/quakes/issues/159">attenuation</a>
+	as <code>regionIntensity</code>.  The response is a FeatureCollection of MultiPolygons, one for each MMI band
+	(e.g., MMI 4 is from 4 up to 5) with a predicted MMI of at least 3.  The <code>localities</code> member is an array of locality
+	Features with the predicted intensity at each town and city (size 0 to 2), ordered by MMI (highest first).</p>`,
+	Example:     "/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`lat`:       `the latitude of the scenario quake e.g., <code>-43.53</code>.`,
+		`lon`:       `the longitude of the scenario quake e.g., <code>172.63</code>.  Longitudes from 180 to 360 are also accepted.`,
+		`depth`:     `the depth (km) of the scenario quake e.g., <code>10</code>.  Must be between <code>0</code> and <code>700</code>.`,
+		`magnitude`: `the magnitude of the scenario quake e.g., <code>7</code>.  Must be between <code>0</code> and <code>10</code>.`,
+	},
+	Props: map[string]template.HTML{
+		`mmi`:        `the lower bound of the <a href="http://info.geonet.org.nz/x/w4IO">Modified Mercalli Intensity (MMI)</a> band e.g., <code>4</code>.`,
+		`intensity`:  `the <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> for the band e.g., <code>light</code>.`,
+		`radius`:     `the distance (km) from the epicenter to the outer edge of the band.`,
+		`localities`: `locality Features with the properties <code>name</code>, <code>size</code>, <code>distance</code>, <code>bearing</code>, <code>mmi</code>, and <code>intensity</code>.`,
+	},
+}
+
+func intensityPredict(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	lat, lon, err := parsePoint(v.Get("lat"), v.Get("lon"))
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	depth, err := strconv.ParseFloat(v.Get("depth"), 64)
+	if err != nil || depth < 0 || depth > 700 {
+		web.BadRequest(w, r, "Invalid depth, must be between 0 and 700: "+v.Get("depth"))
+		return
+	}
+
+	magnitude, err := strconv.ParseFloat(v.Get("magnitude"), 64)
+	if err != nil || magnitude < 0 || magnitude > 10 {
+		web.BadRequest(w, r, "Invalid magnitude, must be between 0 and 10: "+v.Get("magnitude"))
+		return
+	}
+
+	// Minimum depth to avoid numeric instability.  Matches qrt.mmi_in_region.
+	if depth < 5.0 {
+		depth = 5.0
+	}
+
+	origin := "ST_SetSRID(ST_MakePoint($1, $2), 4326)"
+
+	var d string
+
+	err = db.QueryRow(
+		`SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type,
+                         (SELECT COALESCE(array_to_json(array_agg(f)), '[]') FROM (`+contourSQL(origin, "$3::numeric", "$4::numeric")+`) as f) as features,
+                         (SELECT COALESCE(array_to_json(array_agg(l)), '[]') FROM (`+localitySQL(origin, "$3::numeric", "$4::numeric")+`) as l) as localities
+                         ) as fc`, lon, lat, depth, magnitude).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
@@ -2,59 +2,102 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"github.com/GeoNet/web"
 	"github.com/GeoNet/web/api/apidoc"
 	"html/template"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // These constants are the length of parts of the URI and are used for
 // extracting query params embedded in the URI.
 const (
-	quakeLen = 7 //  len("/quake/")
+	quakeLen        = 7  //  len("/quake/")
+	quakeHistoryLen = 15 //  len("/quake/history/")
 )
 
+// defaultMaxQuakes is used for maxQuakes if the ${PREFIX}_MAX_QUAKES env var is not set.
+const defaultMaxQuakes = 10000
+
+// maxQuakes is the largest limit that can be requested for quake queries that allow
+// an arbitrary limit.  From the ${PREFIX}_MAX_QUAKES env var e.g., GEONET_REST_MAX_QUAKES.
+var maxQuakes = configMaxQuakes()
+
+// limitD documents the limit query parameter for quake lists.
+var limitD = template.HTML(fmt.Sprintf(`the maximum number of quakes to return.  Must be between <code>1</code> and <code>%d</code>.  Defaults to <code>%d</code>.`, maxQuakes, maxQuakes))
+
+// maxRadius is the largest radius (km) for quakes near a point.
+const maxRadius = 1000
+
+// maxBody is the largest request body (bytes) that will be read for POST queries.
+const maxBody = 1 << 20
+
+// quakeV2GeoJSON is the Accept header for version 2 of the quake GeoJSON.  See quakeV2D.
+const quakeV2GeoJSON = "application/vnd.geo+json;version=2"
+
 var quakeDoc = apidoc.Endpoint{Title: "Quake",
 	Description: `Look up quake information.`,
+	// the docs for the routes are added ahead of these.
 	Queries: []*apidoc.Query{
-		quakeD,
-		quakesD,
-		quakesRegionD,
+		quakeCSVD,
+		quakesCSVD,
+		fieldsD,
+		quakeQuakeMLD,
+		quakesQuakeMLD,
 	},
 }
 
 var intensityRe = regexp.MustCompile(`^(unnoticeable|weak|light|moderate|strong|severe)$`)
-var numberRe = regexp.MustCompile(`^(3|30|100|500|1000|1500)$`)
 var qualityRe = regexp.MustCompile(`^(best|caution|deleted|good)$`)
 var publicIDRe = regexp.MustCompile(`^[0-9a-z]+$`)
+var typeRe = regexp.MustCompile(`^[a-z ]+$`)
 
 // all requests have the same properties in the return.
 // this is a map for all apidoc.Query{} structs.
 var propsD = map[string]template.HTML{
-	`publicID`:         `the unique public identifier for this quake.`,
-	`time`:             `the origin time of the quake.`,
-	`depth`:            `the depth of the quake in km.`,
-	`magnitude`:        `the summary magnitude for the quake.  This is <b>not</b> Richter magnitude.`,
-	`type`:             `the event type; earthquake, landslide etc.`,
-	`agency`:           `the agency that located this quake.  The official GNS/GeoNet agency name for this field is WEL(*).`,
-	`locality`:         `distance and direction to the nearest locality.`,
-	`intensity`:        `the calculated <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> at the surface above the quake (epicenter) e.g., strong.`,
-	`regionIntensity`:  `the calculated intensity at the closest locality in the region for the request. `,
-	`quality`:          `the quality of this information; <code>best</code>, <code>good</code>, <code>caution</code>, <code>unknown</code>, <code>deleted</code>.`,
-	`modificationTime`: `the modification time of this information.`,
+	`publicID`:              `the unique public identifier for this quake.`,
+	`time`:                  `the origin time of the quake.`,
+	`depth`:                 `the depth of the quake in km.`,
+	`magnitude`:             `the summary magnitude for the quake.  This is <b>not</b> Richter magnitude.`,
+	`type`:                  `the event type; earthquake, landslide etc.`,
+	`agency`:                `the agency that located this quake.  The official GNS/GeoNet agency name for this field is WEL(*).`,
+	`locality`:              `distance and direction to the nearest locality.`,
+	`intensity`:             `the calculated <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> at the surface above the quake (epicenter) e.g., strong.`,
+	`regionIntensity`:       `the calculated intensity at the closest locality in the region for the request. `,
+	`quality`:               `the quality of this information; <code>best</code>, <code>good</code>, <code>caution</code>, <code>unknown</code>, <code>deleted</code>.`,
+	`modificationTime`:      `the modification time of this information.`,
+	`magnitudeType`:         v2OnlyD + `the type of the summary magnitude e.g., <code>ML</code> or <code>Mw</code>.`,
+	`usedPhaseCount`:        v2OnlyD + `the number of phases used to locate the quake.`,
+	`magnitudeStationCount`: v2OnlyD + `the number of stations used for the summary magnitude.`,
+	`status`:                v2OnlyD + `the status of the quake location e.g., <code>automatic</code>, <code>reviewed</code>, <code>deleted</code>.`,
+}
+
+// filterD documents the optional query parameters for filtering quake lists.
+var filterD = map[string]template.HTML{
+	`minMag`:   `the minimum magnitude (inclusive) e.g., <code>3.5</code>.`,
+	`maxMag`:   `the maximum magnitude (inclusive) e.g., <code>6</code>.`,
+	`minDepth`: `the minimum depth (inclusive) in km e.g., <code>40</code>.`,
+	`maxDepth`: `the maximum depth (inclusive) in km e.g., <code>300</code>.`,
+	`type`: `a comma separated list of event types to be included in the response e.g., <code>earthquake</code> or 
+	<code>earthquake,landslide</code>.`,
 }
 
 // /quake/2013p407387
 
 var quakeD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Quake",
 	Description: "Information for a single quake.",
 	Example:     "/quake/2013p407387",
 	ExampleHost: exHost,
-	URI:         "/quake/(publicID)",
 	Params: map[string]template.HTML{
 		"publicID": `a valid quake ID e.g., <code>2014p715167</code>`,
 	},
@@ -62,16 +105,11 @@ var quakeD = &apidoc.Query{
 }
 
 func quake(w http.ResponseWriter, r *http.Request) {
-	if len(r.URL.Query()) != 0 {
-		web.BadRequest(w, r, "incorrect number of query parameters.")
-		return
-	}
-
 	publicID := r.URL.Path[quakeLen:]
 
-	// TODO bother with this?
-	if !publicIDRe.MatchString(publicID) {
-		web.BadRequest(w, r, "invalid publicID: "+publicID)
+	gf, err := newGeoJSONFormat(r)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
 		return
 	}
 
@@ -79,7 +117,63 @@ func quake(w http.ResponseWriter, r *http.Request) {
 
 	// Check that the publicid exists in the DB.  This is needed as the handle method will return empty
 	// JSON for an invalid publicID.
-	err := db.QueryRow("select publicid FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&d)
+	err = db.QueryRow("select publicid FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&d)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	err = db.QueryRow(
+		`SELECT `+gf.collection()+`
+                         FROM ( SELECT 'FeatureCollection' as type, `+gf.featureArray()+` as features`+gf.bbox()+`
+                         FROM (SELECT `+gf.quakeFeature(quakeProps)+`
+                         FROM qrt.quake_materialized as q where publicid = $1 ) As f )  as fc`, publicID).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
+
+// /quake/history/2011a440804
+
+var quakeHistoryD = &apidoc.Query{
+	Title:       "Quake History",
+	Description: "Each recorded version of the information for a single quake, ordered by modification time (oldest first).",
+	Discussion: `<p>Quake information is updated as more data arrives and the quake is reviewed.  
+	This query returns each version of the location and magnitude so that changes can be explained.  
+	The history is also available for quakes that have been deleted.</p>`,
+	Example:     "/quake/history/2011a440804",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		"publicID": `a valid quake ID e.g., <code>2014p715167</code>`,
+	},
+	Props: map[string]template.HTML{
+		`publicID`:         `the unique public identifier for this quake.`,
+		`time`:             `the origin time of the quake in this version.`,
+		`depth`:            `the depth of the quake in km in this version.`,
+		`magnitude`:        `the summary magnitude for the quake in this version.  This is <b>not</b> Richter magnitude.`,
+		`magnitudeType`:    `the type of the summary magnitude e.g., <code>ML</code>.`,
+		`type`:             `the event type; earthquake, landslide etc.`,
+		`status`:           `the status of this version e.g., <code>automatic</code> or <code>reviewed</code>.`,
+		`quality`:          `the quality of this version; <code>best</code>, <code>good</code>, <code>caution</code>, <code>unknown</code>, <code>deleted</code>.`,
+		`modificationTime`: `the modification time of this version.`,
+	},
+}
+
+func quakeHistory(w http.ResponseWriter, r *http.Request) {
+	publicID := r.URL.Path[quakeHistoryLen:]
+
+	var d string
+
+	// Check the history not the current quakes so that deleted quakes still have their history.
+	err := db.QueryRow("select publicid FROM qrt.eventhistory where publicid = $1 limit 1", publicID).Scan(&d)
 	if err == sql.ErrNoRows {
 		web.NotFound(w, r, "invalid publicID: "+publicID)
 		return
@@ -91,25 +185,24 @@ func quake(w http.ResponseWriter, r *http.Request) {
 
 	err = db.QueryRow(
 		`SELECT row_to_json(fc)
-                         FROM ( SELECT 'FeatureCollection' as type, array_to_json(array_agg(f)) as features
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
                          FROM (SELECT 'Feature' as type,
-                         ST_AsGeoJSON(q.origin_geom)::json as geometry,
-                         row_to_json((SELECT l FROM 
+                         ST_AsGeoJSON(ST_SetSRID(ST_MakePoint(h.longitude, h.latitude), 4326))::json as geometry,
+                         row_to_json((SELECT l FROM
                          	(
-                         		SELECT 
+                         		SELECT
                          		publicid AS "publicID",
                                 to_char(origintime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "time",
-                                depth, 
-                                magnitude, 
-                                type, 
-                                agency, 
-                                locality,
-                                qrt.mmi_to_intensity(maxmmi) as intensity,
-                                qrt.mmi_to_intensity(mmi_newzealand) as "regionIntensity",
+                                depth,
+                                magnitude,
+                                magnitudetype as "magnitudeType",
+                                type,
+                                status,
                                 qrt.quake_quality(status, usedphasecount, magnitudestationcount) as quality,
                                 to_char(updatetime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "modificationTime"
                            ) as l
-                         )) as properties FROM qrt.quake_materialized as q where publicid = $1 ) As f )  as fc`, publicID).Scan(&d)
+                         )) as properties FROM qrt.eventhistory as h where publicid = $1
+                         order by updatetime, eventid ) as f ) as fc`, publicID).Scan(&d)
 	if err != nil {
 		web.ServiceUnavailable(w, r, err)
 		return
@@ -119,59 +212,36 @@ func quake(w http.ResponseWriter, r *http.Request) {
 	web.Ok(w, r, &b)
 }
 
-// /quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good
+// numberD documents the number query parameter that was used before limit for quakes in a region.
+var numberD = map[string]template.HTML{
+	`number`: `the same as <code>limit</code>.  Can not be used with <code>limit</code>.`,
+}
+
+// /quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good&limit=30
 var quakesRegionD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Quakes Possibly Felt in a Region",
 	Description: "quakes possibly felt in a region during the last 365 days.",
-	Example:     "/quake?regionID=newzealand&regionIntensity=weak&number=3&quality=best,caution,good",
+	Example:     "/quake?regionID=newzealand&regionIntensity=weak&quality=best,caution,good&limit=3",
 	ExampleHost: exHost,
-	URI:         "/quake?regionID=(region)&regionIntensity=(intensity)&number=(n)&quality=(quality)",
 	Required: map[string]template.HTML{
 		`regionID`: `a valid quake region identifier e.g., <code>newzealand</code>.`,
 		`regionIntensity`: `the minimum intensity in the region e.g., <code>weak</code>.  
 		Must be one of <code>unnoticeable</code>, <code>weak</code>, <code>light</code>, 
 		<code>moderate</code>, <code>strong</code>, <code>severe</code>.`,
-		`number`: `the maximum number of quakes to return.  Must be one of 
-		<code>3</code>, <code>30</code>, <code>100</code>, <code>500</code>, <code>1000</code>, <code>1500</code>.`,
 		`quality`: `a comma separated list of quality values to be included in the response: 
 		<code>best</code>, <code>caution</code>, <code>deleted</code>, <code>good</code>.`,
 	},
-	Props: propsD,
+	Optional: mergeHTML(filterD, pageD, numberD),
+	Props:    mergeHTML(propsD, nextD),
 }
 
 func quakesRegion(w http.ResponseWriter, r *http.Request) {
-	if err := quakesRegionD.CheckParams(r.URL.Query()); err != nil {
-		web.BadRequest(w, r, err.Error())
-		return
-	}
-
 	v := r.URL.Query()
 
-	number := v.Get("number")
 	regionID := v.Get("regionID")
 	regionIntensity := v.Get("regionIntensity")
-	quality := strings.Split(v.Get("quality"), ",")
-
-	if !numberRe.MatchString(number) {
-		web.BadRequest(w, r, "Invalid query parameter number: "+number)
-		return
-	}
-
-	if !intensityRe.MatchString(regionIntensity) {
-		web.BadRequest(w, r, "Invalid regionIntensity: "+regionIntensity)
-		return
-	}
-
-	for _, q := range quality {
-		if !qualityRe.MatchString(q) {
-			web.BadRequest(w, r, "Invalid quality: "+q)
-			return
-		}
-	}
 
-	var d string
-	err := db.QueryRow("select regionname FROM qrt.region where regionname = $1 AND groupname in ('region', 'north', 'south')", regionID).Scan(&d)
+	f, err := regionFilter(regionID)
 	if err == sql.ErrNoRows {
 		web.BadRequest(w, r, "invalid quake regionID: "+regionID)
 		return
@@ -181,126 +251,731 @@ func quakesRegion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.QueryRow(
-		`SELECT row_to_json(fc)
-                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
-                         FROM (SELECT 'Feature' as type,
-                         ST_AsGeoJSON(q.origin_geom)::json as geometry,
-                         row_to_json((SELECT l FROM
-                         	(
-                         		SELECT
-                         		publicid AS "publicID",
-                                to_char(origintime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "time",
-                                depth,
-                                magnitude,
-                                type,
-                                agency,
-                                locality,
-                                intensity,
-                                intensity_`+regionID+` as "regionIntensity",
-                                quality,
-                                to_char(updatetime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "modificationTime"
-                           ) as l
-                         )) as properties FROM qrt.quakeinternal_v2 as q where mmi_`+regionID+` >= qrt.intensity_to_mmi($1)
-                         AND quality in ('`+strings.Join(quality, `','`)+`') limit $2 ) as f ) as fc`, regionIntensity, number).Scan(&d)
-	if err != nil {
-		web.ServiceUnavailable(w, r, err)
-		return
-	}
+	f.add("m.mmi >= qrt.intensity_to_mmi(?)", regionIntensity)
 
-	b := []byte(d)
-	web.Ok(w, r, &b)
+	quakesPage(w, r, f)
 }
 
-// /quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good
+// /quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good&limit=30
 
 var quakesD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Quakes in a Region",
 	Description: "quakes in a region during the last 365 days.",
-	Example:     "/quake?regionID=newzealand&intensity=weak&number=3&quality=best,caution,good",
+	Example:     "/quake?regionID=newzealand&intensity=weak&quality=best,caution,good&limit=3",
 	ExampleHost: exHost,
-	URI:         " /quake?regionID=(region)&intensity=(intensity)&number=(n)&quality=(quality)",
 	Required: map[string]template.HTML{
 		`regionID`: `a valid quake region identifier e.g., <code>newzealand</code>.`,
 		`intensity`: `the minimum intensity at the epicenter e.g., <code>weak</code>.  
 		Must be one of <code>unnoticeable</code>, <code>weak</code>, <code>light</code>, 
 		<code>moderate</code>, <code>strong</code>, <code>severe</code>.`,
-		`number`: `the maximum number of quakes to return.  Must be one of 
-		<code>3</code>, <code>30</code>, <code>100</code>, <code>500</code>, <code>1000</code>, <code>1500</code>.`,
 		`quality`: `a comma separated list of quality values to be included in the response: 
 		<code>best</code>, <code>caution</code>, <code>deleted</code>, <code>good</code>.`,
 	},
-	Props: propsD,
+	Optional: mergeHTML(filterD, pageD, numberD),
+	Props:    mergeHTML(propsD, nextD),
 }
 
 func quakes(w http.ResponseWriter, r *http.Request) {
-	if err := quakesD.CheckParams(r.URL.Query()); err != nil {
-		web.BadRequest(w, r, err.Error())
-		return
-	}
-
 	v := r.URL.Query()
 
-	number := v.Get("number")
 	regionID := v.Get("regionID")
 	intensity := v.Get("intensity")
-	quality := strings.Split(v.Get("quality"), ",")
 
-	if !numberRe.MatchString(number) {
-		web.BadRequest(w, r, "Invalid query parameter number: "+number)
+	f, err := regionFilter(regionID)
+	if err == sql.ErrNoRows {
+		web.BadRequest(w, r, "invalid quake regionID: "+regionID)
 		return
 	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	f.add("maxmmi >= qrt.intensity_to_mmi(?)", intensity)
+	f.add("ST_Contains((select geom from qrt.region where regionname = ?), ST_Shift_Longitude(q.origin_geom))", regionID)
 
-	if !intensityRe.MatchString(intensity) {
-		web.BadRequest(w, r, "Invalid intensity: "+intensity)
+	quakesPage(w, r, f)
+}
+
+// regionFilter returns a filter for quakes in the New Zealand region in the last 365 days with the regionIntensity
+// for the quake region regionID.
+// Returns sql.ErrNoRows if regionID is not a quake region.
+func regionFilter(regionID string) (f quakeFilter, err error) {
+	var d string
+	err = db.QueryRow("select regionname FROM qrt.region where regionname = $1 AND groupname in ('region', 'north', 'south')", regionID).Scan(&d)
+	if err != nil {
 		return
 	}
 
-	for _, q := range quality {
-		if !qualityRe.MatchString(q) {
-			web.BadRequest(w, r, "Invalid quality: "+q)
+	f.from = `qrt.quake_materialized as q JOIN qrt.quake_region_mmi as m ON m.publicid = q.publicid AND m.regionname = ` + f.bind("?", regionID)
+	f.properties = withProp(quakeProps, featureProp{name: "regionIntensity", sql: `qrt.mmi_to_intensity(m.mmi::double precision)`})
+	f.add("in_nz_region is true")
+	f.add("q.origintime > current_date - interval '1 year'")
+
+	return
+}
+
+// quakesPage writes a page of the quakes selected by f using the quality, filter, and paging query parameters in r.
+// The number query parameter is the same as limit.
+func quakesPage(w http.ResponseWriter, r *http.Request, f quakeFilter) {
+	v := r.URL.Query()
+
+	if err := f.addQuality(v.Get("quality")); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addRanges(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if v.Get("number") != "" {
+		if v.Get("limit") != "" {
+			web.BadRequest(w, r, "number and limit can not be used together.")
 			return
 		}
+		v.Set("limit", v.Get("number"))
 	}
 
-	var d string
-	err := db.QueryRow("select regionname FROM qrt.region where regionname = $1 AND groupname in ('region', 'north', 'south')", regionID).Scan(&d)
-	if err == sql.ErrNoRows {
-		web.BadRequest(w, r, "invalid quake regionID: "+regionID)
+	writeQuakePage(w, r, &f, v)
+}
+
+// quakeProps are the properties, in order, for a quake Feature from qrt.quake_materialized (as q).
+// They are the same properties as for a single quake.  regionIntensity is for the newzealand region from
+// qrt.quake_region_mmi.  Queries for another region replace it with withProp.
+var quakeProps = []featureProp{
+	{name: "publicID", sql: `q.publicid`},
+	{name: "time", sql: `q.origintime`, time: true},
+	{name: "depth", sql: `q.depth`},
+	{name: "magnitude", sql: `q.magnitude`},
+	{name: "type", sql: `q.type`},
+	{name: "agency", sql: `q.agency`},
+	{name: "locality", sql: `q.locality`},
+	{name: "intensity", sql: `qrt.mmi_to_intensity(q.maxmmi)`},
+	{name: "regionIntensity", sql: `qrt.mmi_to_intensity((SELECT m.mmi::double precision FROM qrt.quake_region_mmi as m
+		WHERE m.publicid = q.publicid AND m.regionname = 'newzealand'))`},
+	{name: "quality", sql: `qrt.quake_quality(q.status, q.usedphasecount, q.magnitudestationcount)`},
+	{name: "modificationTime", sql: `q.updatetime`, time: true},
+	{name: "magnitudeType", sql: `q.magnitudetype`, v2: true},
+	{name: "usedPhaseCount", sql: `q.usedphasecount`, v2: true},
+	{name: "magnitudeStationCount", sql: `q.magnitudestationcount`, v2: true},
+	{name: "status", sql: `q.status`, v2: true},
+}
+
+// isQuakeV2 returns true if r is for version 2 of the quake GeoJSON.
+func isQuakeV2(r *http.Request) bool {
+	return mediaTypeOf(r) == quakeV2GeoJSON
+}
+
+// /quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=100
+
+var quakesTimeD = &apidoc.Query{
+	Title:       "Quakes in a Time Window",
+	Description: "quakes with an origin time in a time window, ordered by origin time (most recent first).",
+	Example:     "/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=100",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`startTime`: `the start of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-30T00:00:00Z</code>.
+		A date e.g., <code>2013-05-30</code> is also accepted and is the start of that day UTC.`,
+		`endTime`: `the end of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-31T00:00:00Z</code>.
+		Must be after <code>startTime</code>.`,
+	},
+	Optional: mergeHTML(filterD, pageD),
+	Props:    mergeHTML(propsD, nextD),
+}
+
+func quakesTime(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	var f quakeFilter
+
+	if err := f.addTimeWindow(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addRanges(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	writeQuakePage(w, r, &f, v)
+}
+
+// /quake?bbox=172.0,-44.0,173.0,-43.0&startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=100
+
+var quakesBBoxD = &apidoc.Query{
+	Title:       "Quakes in a Bounding Box",
+	Description: "quakes with an epicenter inside a bounding box, ordered by origin time (most recent first).",
+	Discussion: `<p>The bounding box is specified as <code>minLon,minLat,maxLon,maxLat</code> with longitudes between 
+	<code>-180</code> and <code>180</code>.  A bounding box that crosses the 180&deg; meridian has <code>minLon</code> 
+	greater than <code>maxLon</code> e.g., <code>bbox=175,-40,-175,-30</code>.</p>`,
+	Example:     "/quake?bbox=172.0,-44.0,173.0,-43.0&limit=100",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`bbox`: `the bounding box <code>minLon,minLat,maxLon,maxLat</code> e.g., <code>172.0,-44.0,173.0,-43.0</code>.`,
+	},
+	Optional: mergeHTML(filterD, map[string]template.HTML{
+		`startTime`: `the start of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-30T00:00:00Z</code>.`,
+		`endTime`:   `the end of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-31T00:00:00Z</code>.`,
+	}, pageD),
+	Props: mergeHTML(propsD, nextD),
+}
+
+func quakesBBox(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	var f quakeFilter
+
+	if err := f.addBBox(v.Get("bbox")); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addTimeWindow(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addRanges(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	writeQuakePage(w, r, &f, v)
+}
+
+// POST /quake with a GeoJSON Polygon in the request body.
+
+var quakesPolygonD = &apidoc.Query{
+	Title:       "Quakes in a Polygon",
+	Description: "quakes with an epicenter inside a polygon, ordered by origin time (most recent first).",
+	Discussion: `<p>This query uses http <code>POST</code>.  The request body must be a GeoJSON <code>Polygon</code> or 
+	<code>MultiPolygon</code> geometry, or a <code>Feature</code> with one of those geometries, no larger than 1 MB, and the 
+	<code>Content-Type</code> must be <code>application/geo+json</code>.  
+	Coordinates are longitude, latitude (WGS84).  Polygons that cross the 180&deg; meridian should use longitudes between <code>0</code> and 
+	<code>360</code> e.g., <code>[[[175,-40],[185,-40],[185,-30],[175,-30],[175,-40]]]</code>.</p>
+	<pre>curl -X POST -H "Content-Type: application/geo+json" -H "Accept: application/vnd.geo+json;version=1" -d '{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[173,-43],[172,-43],[172,-44]]]}' "http://...API-HOST.../quake"</pre>`,
+	Optional: mergeHTML(filterD, map[string]template.HTML{
+		`startTime`: `the start of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-30T00:00:00Z</code>.`,
+		`endTime`:   `the end of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-31T00:00:00Z</code>.`,
+	}, pageD),
+	Props: mergeHTML(propsD, nextD),
+}
+
+func quakesPolygon(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	var f quakeFilter
+
+	if err := f.addTimeWindow(v); err != nil {
+		web.BadRequest(w, r, err.Error())
 		return
 	}
+
+	if err := f.addRanges(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
 	if err != nil {
-		web.ServiceUnavailable(w, r, err)
+		web.BadRequest(w, r, "request body too large.")
 		return
 	}
 
-	err = db.QueryRow(
-		`SELECT row_to_json(fc)
-                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
-                         FROM (SELECT 'Feature' as type,
-                         ST_AsGeoJSON(q.origin_geom)::json as geometry,
-                         row_to_json((SELECT l FROM
-                         	(
-                         		SELECT
-                         		publicid AS "publicID",
-                                to_char(origintime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "time",
-                                depth,
-                                magnitude,
-                                type,
-                                agency,
-                                locality,
-                                intensity,
-                                intensity_`+regionID+` as "regionIntensity",
-                                quality,
-                                to_char(updatetime, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"') as "modificationTime"
-                           ) as l
-                         )) as properties FROM qrt.quakeinternal_v2 as q where maxmmi >= qrt.intensity_to_mmi($1)
-                         AND quality in ('`+strings.Join(quality, `','`)+`')  AND ST_Contains((select geom from qrt.region where regionname = $3), ST_Shift_Longitude(origin_geom)) limit $2 ) as f ) as fc`, intensity, number, regionID).Scan(&d)
+	polygon, err := polygonGeometry(body)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	// Check that the geometry is valid before using it in a query.
+	var valid bool
+	err = db.QueryRow("select ST_IsValid(ST_GeomFromGeoJSON($1))", polygon).Scan(&valid)
 	if err != nil {
 		web.ServiceUnavailable(w, r, err)
 		return
 	}
-	b := []byte(d)
-	web.Ok(w, r, &b)
+
+	if !valid {
+		web.BadRequest(w, r, "invalid GeoJSON polygon.")
+		return
+	}
+
+	// Test the quake location and the quake location shifted to 0-360 so that
+	// polygons that cross the 180 meridian also work.
+	f.add(`(ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), q.origin_geom)
+		OR ST_Intersects(ST_SetSRID(ST_GeomFromGeoJSON(?), 4326), ST_Shift_Longitude(q.origin_geom)))`, polygon, polygon)
+
+	writeQuakePage(w, r, &f, v)
+}
+
+// polygonGeometry returns the GeoJSON Polygon or MultiPolygon geometry from b.
+// b may be a geometry or a Feature with a geometry.
+func polygonGeometry(b []byte) (string, error) {
+	var g struct {
+		Type        string
+		Geometry    json.RawMessage
+		Coordinates json.RawMessage
+	}
+
+	if err := json.Unmarshal(b, &g); err != nil {
+		return "", fmt.Errorf("request body is not GeoJSON.")
+	}
+
+	if g.Type == "Feature" {
+		b = []byte(g.Geometry)
+		g.Type = ""
+		if err := json.Unmarshal(b, &g); err != nil {
+			return "", fmt.Errorf("request body is not a GeoJSON Feature.")
+		}
+	}
+
+	// Check the coordinates so that only a problem with the DB is an error from ST_GeomFromGeoJSON.
+	var err error
+
+	switch g.Type {
+	case "Polygon":
+		var c [][][]float64
+		err = json.Unmarshal(g.Coordinates, &c)
+		if err == nil {
+			err = checkRings(c)
+		}
+	case "MultiPolygon":
+		var c [][][][]float64
+		err = json.Unmarshal(g.Coordinates, &c)
+		for i := 0; err == nil && i < len(c); i++ {
+			err = checkRings(c[i])
+		}
+		if err == nil && len(c) == 0 {
+			err = fmt.Errorf("empty")
+		}
+	default:
+		return "", fmt.Errorf("GeoJSON geometry must be a Polygon or MultiPolygon.")
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("invalid GeoJSON %s coordinates.", g.Type)
+	}
+
+	return string(b), nil
+}
+
+// checkRings returns an error if rings are not polygon linear rings with
+// at least four positions of longitude and latitude.
+func checkRings(rings [][][]float64) error {
+	if len(rings) == 0 {
+		return fmt.Errorf("empty")
+	}
+
+	for _, ring := range rings {
+		if len(ring) < 4 {
+			return fmt.Errorf("too few positions")
+		}
+
+		for _, p := range ring {
+			if len(p) < 2 {
+				return fmt.Errorf("short position")
+			}
+		}
+	}
+
+	return nil
+}
+
+// /quake?lat=-43.5&lon=172.6&radius=50&startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=100
+
+var quakesRadiusD = &apidoc.Query{
+	Title:       "Quakes Near a Point",
+	Description: "quakes with an epicenter within a distance of a point, ordered by origin time (most recent first).",
+	Example:     "/quake?lat=-43.5&lon=172.6&radius=50&limit=100",
+	ExampleHost: exHost,
+	Required: map[string]template.HTML{
+		`lat`:    `the latitude of the point e.g., <code>-43.5</code>.`,
+		`lon`:    `the longitude of the point between <code>-180</code> and <code>360</code> e.g., <code>172.6</code>.`,
+		`radius`: `the distance from the point in km.  Must be greater than <code>0</code> and no more than <code>1000</code>.`,
+	},
+	Optional: mergeHTML(filterD, map[string]template.HTML{
+		`startTime`: `the start of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-30T00:00:00Z</code>.`,
+		`endTime`:   `the end of the time window (inclusive) as an ISO8601 date time e.g., <code>2013-05-31T00:00:00Z</code>.`,
+	}, pageD),
+	Props: mergeHTML(propsD, nextD, map[string]template.HTML{
+		`distance`: `the distance in km from the point to the epicenter.`,
+	}),
+}
+
+func quakesRadius(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	var f quakeFilter
+
+	if err := f.addRadius(v.Get("lat"), v.Get("lon"), v.Get("radius")); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addTimeWindow(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	if err := f.addRanges(v); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	writeQuakePage(w, r, &f, v)
+}
+
+// parseTime parses an ISO8601 date time e.g., 2013-05-30T15:15:37.812Z or a date e.g., 2013-05-30.
+// Date times without a time zone are UTC.
+func parseTime(s string) (t time.Time, err error) {
+	for _, l := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999", "2006-01-02"} {
+		if t, err = time.Parse(l, s); err == nil {
+			return
+		}
+	}
+
+	return
+}
+
+// configMaxQuakes returns the value of the ${PREFIX}_MAX_QUAKES env var or defaultMaxQuakes if it is not set.
+// cfg.WebServer doesn't have the setting so it is read here instead of from the config.
+func configMaxQuakes() int {
+	if config.Env == nil {
+		return defaultMaxQuakes
+	}
+
+	s := os.Getenv(config.Env.Prefix + "_MAX_QUAKES")
+	if s == "" {
+		return defaultMaxQuakes
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		log.Fatalf("invalid %s_MAX_QUAKES: %s", config.Env.Prefix, s)
+	}
+
+	return n
+}
+
+// parseLimit parses the limit query parameter.  An empty string returns maxQuakes.
+func parseLimit(s string) (limit int, err error) {
+	if s == "" {
+		return maxQuakes, err
+	}
+
+	limit, err = strconv.Atoi(s)
+	if err != nil || limit < 1 || limit > maxQuakes {
+		err = fmt.Errorf("Invalid limit, must be between 1 and %d: %s", maxQuakes, s)
+	}
+
+	return
+}
+
+// quakeFilter accumulates SQL where clauses, and their arguments, for
+// selecting quakes from qrt.quake_materialized (as q) or from f.from.
+type quakeFilter struct {
+	from       string        // the quakes to select from as q.  Defaults to qrt.quake_materialized.
+	properties []featureProp // the properties for each quake.  Defaults to quakeProps.
+	where      []string
+	props      []featureProp // additional properties for each quake.
+	args       []interface{}
+	format     geoJSONFormat
+}
+
+// add appends clause to the filter.  Use ? in clause as the placeholder for each of args,
+// they are replaced with numbered query parameters.
+func (f *quakeFilter) add(clause string, args ...interface{}) {
+	f.where = append(f.where, f.bind(clause, args...))
+}
+
+// addProp adds the additional property name to each quake.  prop is an SQL expression
+// and uses ? placeholders for args in the same way as add.
+func (f *quakeFilter) addProp(name, prop string, args ...interface{}) {
+	f.props = append(f.props, featureProp{name: name, sql: f.bind(prop, args...)})
+}
+
+// bind appends args to the query arguments and replaces the ? placeholders in s
+// with the matching numbered query parameters.
+func (f *quakeFilter) bind(s string, args ...interface{}) string {
+	for _, a := range args {
+		f.args = append(f.args, a)
+		s = strings.Replace(s, "?", "$"+strconv.Itoa(len(f.args)), 1)
+	}
+
+	return s
+}
+
+// addTimeWindow adds the startTime and endTime query parameters in v (if present) to the filter.
+func (f *quakeFilter) addTimeWindow(v url.Values) error {
+	var start, end time.Time
+	var err error
+
+	if s := v.Get("startTime"); s != "" {
+		if start, err = parseTime(s); err != nil {
+			return fmt.Errorf("Invalid startTime: %s", s)
+		}
+		f.add("origintime >= ?", start)
+	}
+
+	if s := v.Get("endTime"); s != "" {
+		if end, err = parseTime(s); err != nil {
+			return fmt.Errorf("Invalid endTime: %s", s)
+		}
+		f.add("origintime <= ?", end)
+	}
+
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return fmt.Errorf("endTime must be after startTime.")
+	}
+
+	return nil
+}
+
+// addRanges adds the minMag, maxMag, minDepth, maxDepth, and type query parameters in v (if present) to the filter.
+func (f *quakeFilter) addRanges(v url.Values) error {
+	for _, r := range []struct{ min, max, column string }{
+		{"minMag", "maxMag", "magnitude"},
+		{"minDepth", "maxDepth", "depth"},
+	} {
+		var min, max float64
+		var err error
+
+		if s := v.Get(r.min); s != "" {
+			if min, err = strconv.ParseFloat(s, 64); err != nil {
+				return fmt.Errorf("Invalid %s: %s", r.min, s)
+			}
+			f.add(r.column+" >= ?", min)
+		}
+
+		if s := v.Get(r.max); s != "" {
+			if max, err = strconv.ParseFloat(s, 64); err != nil {
+				return fmt.Errorf("Invalid %s: %s", r.max, s)
+			}
+			f.add(r.column+" <= ?", max)
+		}
+
+		if v.Get(r.min) != "" && v.Get(r.max) != "" && min > max {
+			return fmt.Errorf("%s must not be greater than %s.", r.min, r.max)
+		}
+	}
+
+	if s := v.Get("type"); s != "" {
+		types := strings.Split(s, ",")
+		var p []string
+		var args []interface{}
+
+		for _, t := range types {
+			if !typeRe.MatchString(t) {
+				return fmt.Errorf("Invalid type: %s", t)
+			}
+			p = append(p, "?")
+			args = append(args, t)
+		}
+
+		f.add("type in ("+strings.Join(p, ", ")+")", args...)
+	}
+
+	return nil
+}
+
+// addQuality adds a comma separated list of quality values to the filter.  The quality is calculated
+// in the same way as the quality property.
+func (f *quakeFilter) addQuality(quality string) error {
+	var p []string
+	var args []interface{}
+
+	for _, q := range strings.Split(quality, ",") {
+		if !qualityRe.MatchString(q) {
+			return fmt.Errorf("Invalid quality: %s", q)
+		}
+		p = append(p, "?")
+		args = append(args, q)
+	}
+
+	f.add("qrt.quake_quality(q.status, q.usedphasecount, q.magnitudestationcount) in ("+strings.Join(p, ", ")+")", args...)
+
+	return nil
+}
+
+// featureCollection returns a GeoJSON FeatureCollection of at most limit quakes selected by the filter,
+// ordered by origin time (most recent first).  Duplicate quakes are never included.
+func (f *quakeFilter) featureCollection(limit int) (d string, err error) {
+	err = db.QueryRow(
+		`SELECT `+f.format.collection()+`
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(`+f.format.featureArray()+`, '[]') as features`+f.format.bbox()+`
+                         FROM (`+f.features(limit)+`) as f ) as fc`, f.args...).Scan(&d)
+
+	return
+}
+
+// featurePage returns a GeoJSON FeatureCollection of at most limit quakes selected by the filter,
+// ordered by origin time (most recent first).  The next member is the cursor for the following page or null if
+// this is the last page.  Duplicate quakes are never included.
+func (f *quakeFilter) featurePage(limit int) (d string, next string, err error) {
+	if next, err = f.next(limit); err != nil {
+		return
+	}
+
+	var n interface{}
+	if next != "" {
+		n = next
+	}
+
+	features := f.features(limit)
+
+	err = db.QueryRow(
+		`SELECT `+f.format.collection()+`
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(`+f.format.featureArray()+`, '[]') as features`+f.format.bbox()+`,
+                         `+f.bind("?::text", n)+` as next
+                         FROM (`+features+`) as f ) as fc`, f.args...).Scan(&d)
+
+	return
+}
+
+// features returns an SQL query for at most limit quake Features selected by the filter,
+// ordered by origin time (most recent first).
+func (f *quakeFilter) features(limit int) string {
+	props := quakeProps
+	if f.properties != nil {
+		props = f.properties
+	}
+
+	// copy props so that the additional properties are not appended to a shared slice.
+	props = append(append([]featureProp{}, props...), f.props...)
+
+	return `SELECT ` + f.format.quakeFeature(props) + `
+                         FROM ` + f.source() + ` where ` + f.conditions() + `
+                         order by q.origintime desc, q.publicid desc limit ` + strconv.Itoa(limit)
+}
+
+// next returns the cursor for the page after the first limit quakes selected by the filter or an empty
+// string if there are no more quakes.
+func (f *quakeFilter) next(limit int) (string, error) {
+	rows, err := db.Query(`SELECT q.origintime, q.publicid FROM `+f.source()+` where `+f.conditions()+`
+		order by q.origintime desc, q.publicid desc offset `+strconv.Itoa(limit-1)+` limit 2`, f.args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var c []quakeCursor
+
+	for rows.Next() {
+		var q quakeCursor
+		if err = rows.Scan(&q.time, &q.publicID); err != nil {
+			return "", err
+		}
+		c = append(c, q)
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	// the first row is the last quake in the page.  A second row means there are more quakes.
+	if len(c) < 2 {
+		return "", nil
+	}
+
+	return c[0].String(), nil
+}
+
+// source returns the FROM clause for the filter.
+func (f *quakeFilter) source() string {
+	if f.from != "" {
+		return f.from
+	}
+
+	return "qrt.quake_materialized as q"
+}
+
+// conditions returns the where clauses for the filter.  Duplicate quakes are never included.
+func (f *quakeFilter) conditions() string {
+	return strings.Join(append([]string{"q.status != 'duplicate'"}, f.where...), " AND ")
+}
+
+// addBBox adds a bounding box minLon,minLat,maxLon,maxLat to the filter.
+func (f *quakeFilter) addBBox(bbox string) error {
+	p := strings.Split(bbox, ",")
+	if len(p) != 4 {
+		return fmt.Errorf("Invalid bbox, must be minLon,minLat,maxLon,maxLat: %s", bbox)
+	}
+
+	var c [4]float64
+	for i := range p {
+		var err error
+		if c[i], err = strconv.ParseFloat(strings.TrimSpace(p[i]), 64); err != nil {
+			return fmt.Errorf("Invalid bbox, must be minLon,minLat,maxLon,maxLat: %s", bbox)
+		}
+	}
+
+	if err := f.addEnvelope(c[0], c[1], c[2], c[3]); err != nil {
+		return fmt.Errorf("Invalid bbox, %s: %s", err.Error(), bbox)
+	}
+
+	return nil
+}
+
+// addEnvelope adds a bounding box to the filter.  A box with minLon greater than maxLon
+// crosses the 180 meridian.
+func (f *quakeFilter) addEnvelope(minLon, minLat, maxLon, maxLat float64) error {
+	switch {
+	case minLon < -180 || minLon > 180 || maxLon < -180 || maxLon > 180:
+		return fmt.Errorf("longitudes must be between -180 and 180")
+	case minLat < -90 || minLat > 90 || maxLat < -90 || maxLat > 90:
+		return fmt.Errorf("latitudes must be between -90 and 90")
+	case minLat >= maxLat:
+		return fmt.Errorf("minLat must be less than maxLat")
+	case minLon == maxLon:
+		return fmt.Errorf("minLon must not equal maxLon")
+	}
+
+	if minLon < maxLon {
+		f.add("ST_Intersects(ST_MakeEnvelope(?, ?, ?, ?, 4326), q.origin_geom)", minLon, minLat, maxLon, maxLat)
+		return nil
+	}
+
+	// The box crosses the 180 meridian.  Extend it past 180 and test both the quake location
+	// and the quake location shifted to 0-360.
+	maxLon += 360
+	f.add(`(ST_Intersects(ST_MakeEnvelope(?, ?, ?, ?, 4326), q.origin_geom)
+		OR ST_Intersects(ST_MakeEnvelope(?, ?, ?, ?, 4326), ST_Shift_Longitude(q.origin_geom)))`,
+		minLon, minLat, maxLon, maxLat, minLon, minLat, maxLon, maxLat)
+
+	return nil
+}
+
+// addRadius adds a filter for quakes within radius km of the point lat, lon and adds a distance (km)
+// property to each quake.  Distances are calculated on the spheroid (geography).
+func (f *quakeFilter) addRadius(lat, lon, radius string) error {
+	la, lo, err := parsePoint(lat, lon)
+	if err != nil {
+		return err
+	}
+
+	ra, err := strconv.ParseFloat(radius, 64)
+	if err != nil || ra <= 0 || ra > maxRadius {
+		return fmt.Errorf("Invalid radius, must be greater than 0 and no more than %d: %s", maxRadius, radius)
+	}
+
+	f.add("ST_DWithin(q.origin_geom::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", lo, la, ra*1000)
+	f.addProp("distance", `round((ST_Distance(q.origin_geom::geography, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography) / 1000)::numeric, 2)`, lo, la)
+
+	return nil
+}
+
+// mergeHTML returns a new map with the entries from each of maps.  Used for combining documentation.
+func mergeHTML(maps ...map[string]template.HTML) map[string]template.HTML {
+	m := make(map[string]template.HTML)
+
+	for _, a := range maps {
+		for k, v := range a {
+			m[k] = v
+		}
+	}
+
+	return m
 }
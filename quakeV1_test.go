@@ -23,11 +23,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
 	"github.com/GeoNet/web/webtest"
+	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type QuakeFeatures struct {
@@ -47,7 +60,7 @@ type QuakeGeometry struct {
 type QuakeProperties struct {
 	Publicid, Time, Modificationtime, Type, Agency string
 	Locality, Intensity, Regionintensity, Quality  string
-	Depth, Magnitude                               float64
+	Depth, Magnitude, Distance                     float64
 }
 
 //## Single Quake
@@ -143,7 +156,7 @@ func TestQuakeV1(t *testing.T) {
 
 //## Quakes Possibly Felt in a Region
 //
-// **GET /quake?regionID=(region)&regionIntensity=(intensity)&number=(n)&quality=(quality)**
+// **GET /quake?regionID=(region)&regionIntensity=(intensity)&quality=(quality)&limit=(n)&cursor=(cursor)**
 //
 // Get quake information from the last 365 days.
 // If no quakes are found for the query parameters then a null features array is returned.
@@ -152,14 +165,13 @@ func TestQuakeV1(t *testing.T) {
 //
 // * `regionID` - a valid quake region identifier e.g., `newzealand`.
 // * `regionIntensity` - the minimum intensity in the region e.g., `weak`.  Must be one of `unnoticeable`, `weak`, `light`, `moderate`, `strong`, `severe`.
-// * `number` - the maximum number of quakes to return.  Must be one of  `3`, `30`, `100`, `500`, `1000`, `1500`.
+// * `limit` - optional.  The maximum number of quakes to return.  Must be between `1` and `10000`.  `number` is the same as `limit`.
+// * `cursor` - optional.  Return the quakes after this cursor from a previous response.
 // * `quality` - a comma separated list of quality values to be included in the response; `best`, `caution`, `deleted`, `good`.
 //
-// *The `number` of quakes that can be returned is restricted to a range of options to improve caching.*
-//
 //### Example request:
 //
-// `/quake?regionID=newzealand&regionIntensity=weak&number=30`
+// `/quake?regionID=newzealand&regionIntensity=weak&quality=best,caution,good&limit=30`
 //
 func TestQuakesRegionV1(t *testing.T) {
 	setup()
@@ -212,11 +224,64 @@ func TestQuakesRegionV1(t *testing.T) {
 	if count == 0 {
 		t.Error("found no deleted quakes in the JSON.")
 	}
+
+	// number used to be limited to 3, 30, 100, 500, 1000 or 1500 and was required.  It is now the same
+	// as limit so number=999 and no number, which were bad requests, return the same quakes as limit.
+	var expected int
+
+	for i, u := range []string{
+		"/quake?regionID=newzealand&regionIntensity=unnoticeable&limit=999&quality=best,caution,good",
+		"/quake?regionID=newzealand&regionIntensity=unnoticeable&number=999&quality=best,caution,good",
+		"/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good",
+	} {
+		c = webtest.Content{
+			Accept: web.V1GeoJSON,
+			URI:    u,
+		}
+
+		if b, err = c.Get(ts); err != nil {
+			t.Fatal(err)
+		}
+
+		f = QuakeFeatures{}
+
+		if err = json.Unmarshal(b, &f); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			expected = len(f.Features)
+		}
+
+		if len(f.Features) == 0 || len(f.Features) != expected {
+			t.Errorf("%s: expected %d features got %d", u, expected, len(f.Features))
+		}
+	}
+
+	// number limits the quakes like limit.
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?regionID=newzealand&regionIntensity=unnoticeable&number=2&quality=best,caution,good",
+	}
+
+	if b, err = c.Get(ts); err != nil {
+		t.Fatal(err)
+	}
+
+	f = QuakeFeatures{}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) != 2 {
+		t.Errorf("expected 2 features for number=2 got %d", len(f.Features))
+	}
 }
 
 //## Quakes in a Region
 //
-// **GET /quake?regionID=(region)&intensity=(intensity)&number=(n)&quality=(quality)**
+// **GET /quake?regionID=(region)&intensity=(intensity)&quality=(quality)&limit=(n)&cursor=(cursor)**
 //
 // Get quake information from the last 365 days.
 // If no quakes are found for the query parameters then a null features array is returned.
@@ -225,14 +290,13 @@ func TestQuakesRegionV1(t *testing.T) {
 //
 // * `regionID` - a valid quake region identifier e.g., `newzealand`.
 // * `intensity` - the minimum intensity at the epicenter e.g., `weak`.  Must be one of `unnoticeable`, `weak`, `light`, `moderate`, `strong`, `severe`.
-// * `number` - the maximum number of quakes to return.  Must be one of `30`, `100`, `500`, `1000`, `1500`.
+// * `limit` - optional.  The maximum number of quakes to return.  Must be between `1` and `10000`.  `number` is the same as `limit`.
+// * `cursor` - optional.  Return the quakes after this cursor from a previous response.
 // * `quality` - a comma separated list of quality values to be included in the response; `best`, `caution`, `deleted`, `good`.
 //
-// *The `number` of quakes that can be returned is restricted to a range of options to improve caching.*
-//
 //### Example request:
 //
-// `/quake?regionID=newzealand&intensity=weak&number=30`
+// `/quake?regionID=newzealand&intensity=weak&quality=best,caution,good&limit=30`
 //
 func TestQuakesV1(t *testing.T) {
 	setup()
@@ -298,3 +362,1864 @@ func TestQuakesV1(t *testing.T) {
 		t.Errorf("Found wrong number of features: %d", len(f.Features))
 	}
 }
+
+//## Quakes in a Time Window
+//
+// **GET /quake?startTime=(ISO8601)&endTime=(ISO8601)&limit=(n)**
+//
+// Get quakes with an origin time between startTime and endTime ordered by origin time (most recent first).
+//
+//### Parameters
+//
+// * `startTime` - the start of the time window as an ISO8601 date time e.g., `2013-05-30T00:00:00Z`.
+// * `endTime` - the end of the time window as an ISO8601 date time e.g., `2013-05-31T00:00:00Z`.
+// * `limit` - optional.  The maximum number of quakes to return.  Must be between `1` and `10000`.
+//
+//### Example request:
+//
+// `/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=100`
+//
+func TestQuakesTimeV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Publicid != "2013p407387" {
+		t.Error("incorrect publicid")
+	}
+
+	// The 2012 test quakes all have the same origin time.
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?startTime=2012-02-04&endTime=2012-02-05&limit=2",
+	}
+
+	b, err = c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 2 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+}
+
+//## Paging Quakes
+//
+// Quake lists are returned in pages of at most `limit` quakes.  If there are more quakes then the `next` member of
+// the FeatureCollection is a cursor for the next page and the `Link` header has the URL for the next page.
+//
+//### Example request:
+//
+// `/quake?startTime=2012-02-04&endTime=2012-02-05&limit=1`
+//
+func TestQuakesPageV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?startTime=2012-02-04&endTime=2012-02-05",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all struct {
+		Features []QuakeFeature
+		Next     *string
+	}
+
+	if err = json.Unmarshal(b, &all); err != nil {
+		t.Fatal(err)
+	}
+
+	if all.Next != nil {
+		t.Errorf("expected null next for the last page got %s", *all.Next)
+	}
+
+	// The 2012 test quakes all have the same origin time so paging has to use the publicID as well.
+	var ids []string
+	link := ts.URL + "/quake?startTime=2012-02-04&endTime=2012-02-05&limit=1"
+
+	for link != "" && len(ids) <= len(all.Features) {
+		req, err := http.NewRequest("GET", link, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("Accept", web.V1GeoJSON)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code for %s: %d", link, res.StatusCode)
+		}
+
+		var f struct {
+			Features []QuakeFeature
+			Next     *string
+		}
+
+		if err = json.Unmarshal(b, &f); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(f.Features) != 1 {
+			t.Fatalf("Found wrong number of features: %d", len(f.Features))
+		}
+
+		ids = append(ids, f.Features[0].Properties.Publicid)
+
+		link = ""
+
+		if l := res.Header.Get("Link"); l != "" {
+			if f.Next == nil {
+				t.Fatal("expected next with a Link header")
+			}
+
+			if !strings.HasPrefix(l, "</quake?") || !strings.HasSuffix(l, `>; rel="next"`) {
+				t.Fatalf("incorrect Link header %s", l)
+			}
+
+			u, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(l, "<"), `>; rel="next"`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if u.Query().Get("cursor") != *f.Next {
+				t.Errorf("expected Link cursor %s got %s", *f.Next, u.Query().Get("cursor"))
+			}
+
+			link = ts.URL + u.String()
+		} else if f.Next != nil {
+			t.Error("expected a Link header with next")
+		}
+	}
+
+	if len(ids) != len(all.Features) {
+		t.Fatalf("expected %d quakes from paging got %d", len(all.Features), len(ids))
+	}
+
+	for i, q := range all.Features {
+		if ids[i] != q.Properties.Publicid {
+			t.Errorf("expected %s for quake %d got %s", q.Properties.Publicid, i, ids[i])
+		}
+	}
+}
+
+func TestQuakeCursor(t *testing.T) {
+	c := quakeCursor{time: time.Date(2013, 5, 30, 15, 15, 37, 812174000, time.UTC), publicID: "2013p407387"}
+
+	p, err := parseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.time.Equal(c.time) || p.publicID != c.publicID {
+		t.Errorf("expected %v got %v", c, p)
+	}
+
+	for _, bad := range []string{"", "bad", c.String() + "!", "MjAxMy0wNS0zMFQxNToxNTozNy44MTIxNzRa"} {
+		if _, err := parseCursor(bad); err == nil {
+			t.Errorf("expected error for cursor %s", bad)
+		}
+	}
+}
+
+//## Quakes in a Bounding Box
+//
+// **GET /quake?bbox=(minLon,minLat,maxLon,maxLat)&startTime=(ISO8601)&endTime=(ISO8601)&limit=(n)**
+//
+// Get quakes with an epicenter in a bounding box ordered by origin time (most recent first).
+//
+//### Parameters
+//
+// * `bbox` - the bounding box `minLon,minLat,maxLon,maxLat`.  A box that crosses the 180 meridian has minLon > maxLon.
+// * `startTime` - optional.  The start of the time window as an ISO8601 date time.
+// * `endTime` - optional.  The end of the time window as an ISO8601 date time.
+// * `limit` - optional.  The maximum number of quakes to return.  Must be between `1` and `10000`.
+//
+//### Example request:
+//
+// `/quake?bbox=172.0,-44.0,173.0,-43.0&limit=100`
+//
+func TestQuakesBBoxV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// There are three Kermadec test quakes either side of the 180 meridian.
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?bbox=179,-38,-179,-37",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 3 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?bbox=172.2,-43.5,172.4,-43.3",
+	}
+
+	b, err = c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Publicid != "2013p407387" {
+		t.Error("incorrect publicid")
+	}
+}
+
+//## Quakes in a Polygon
+//
+// **POST /quake?startTime=(ISO8601)&endTime=(ISO8601)&limit=(n)**
+//
+// Get quakes with an epicenter inside the GeoJSON Polygon or MultiPolygon in the request body.
+//
+//### Example request:
+//
+// `curl -X POST -H "Content-Type: application/geo+json" -d '{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[173,-43],[172,-43],[172,-44]]]}' /quake`
+//
+func TestQuakesPolygonV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	polygon := `{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[172.2,-43.5],[172.4,-43.5],[172.4,-43.3],[172.2,-43.3],[172.2,-43.5]]]}}`
+
+	res, err := http.Post(ts.URL+"/quake", polygonContent, strings.NewReader(polygon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code: %d", res.StatusCode)
+	}
+
+	if res.Header.Get("Content-Type") != web.V1GeoJSON {
+		t.Errorf("wrong content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Publicid != "2013p407387" {
+		t.Error("incorrect publicid")
+	}
+
+	// A polygon in 0-360 that crosses the 180 meridian.
+	polygon = `{"type":"Polygon","coordinates":[[[179,-38],[181,-38],[181,-37],[179,-37],[179,-38]]]}`
+
+	res, err = http.Post(ts.URL+"/quake", polygonContent, strings.NewReader(polygon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 3 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	for _, bad := range []string{`{"type":"Point","coordinates":[172.2,-43.5]}`, `not json`, `{"type":"Polygon","coordinates":"bad"}`, `{"publicID":["2013p407387"]}`} {
+		res, err = http.Post(ts.URL+"/quake", polygonContent, strings.NewReader(bad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected bad request for %s got %d", bad, res.StatusCode)
+		}
+	}
+}
+
+func TestPolygonGeometry(t *testing.T) {
+	in := []struct {
+		body string
+		ok   bool
+	}{
+		{`{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[173,-43],[172,-44]]]}`, true},
+		{`{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[173,-43],[172,-44]]]}}`, true},
+		{`{"type":"MultiPolygon","coordinates":[[[[172,-44],[173,-44],[173,-43],[172,-44]]]]}`, true},
+		{`{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[172,-44]]]}`, false},
+		{`{"type":"Polygon","coordinates":[[[172],[173,-44],[173,-43],[172,-44]]]}`, false},
+		{`{"type":"Polygon","coordinates":[]}`, false},
+		{`{"type":"Polygon"}`, false},
+		{`{"type":"MultiPolygon","coordinates":[]}`, false},
+		{`{"type":"Point","coordinates":[172,-44]}`, false},
+		{`not json`, false},
+	}
+
+	for _, v := range in {
+		if _, err := polygonGeometry([]byte(v.body)); v.ok != (err == nil) {
+			t.Errorf("%s: unexpected error %v", v.body, err)
+		}
+	}
+}
+
+//## Quakes by publicID
+//
+// **GET /quake?publicID=(publicID,publicID,...)**
+//
+// **POST /quake**
+//
+// Get several quakes ordered by origin time (most recent first).  The `notFound` member of the FeatureCollection
+// lists the requested quake IDs that are not known.
+//
+//### Parameters
+//
+// * `publicID` - a comma separated list of up to `500` quake IDs.  For POST a JSON object with a `publicID` array.
+//
+//### Example request:
+//
+// `/quake?publicID=2013p407387,2013p407399`
+//
+// `curl -X POST -H "Content-Type: application/json" -d '{"publicID":["2013p407387","2013p407399"]}' /quake`
+//
+func TestQuakesPublicIDV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?publicID=2013p407387,2013p407399,2013p407387",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []QuakeFeature
+		NotFound []string
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Publicid != "2013p407387" {
+		t.Error("incorrect publicid")
+	}
+
+	if len(f.NotFound) != 1 || f.NotFound[0] != "2013p407399" {
+		t.Errorf("incorrect notFound %v", f.NotFound)
+	}
+
+	res, err := http.Post(ts.URL+"/quake", publicIDsContent, strings.NewReader(`{"publicID":["2013p407399","2013p407387"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code: %d", res.StatusCode)
+	}
+
+	p, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(p) != string(b) {
+		t.Error("expected the same response for GET and POST")
+	}
+
+	for _, bad := range []string{`{"publicID":[]}`, `{"publicID":["2013P407387"]}`, `{"type":"Polygon","coordinates":[[[172,-44],[173,-44],[173,-43],[172,-44]]]}`} {
+		res, err = http.Post(ts.URL+"/quake", publicIDsContent, strings.NewReader(bad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected bad request for %s got %d", bad, res.StatusCode)
+		}
+	}
+
+	// the Content-Type selects the query.
+	res, err = http.Post(ts.URL+"/quake", "text/plain", strings.NewReader(`{"publicID":["2013p407387"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for text/plain got %d", res.StatusCode)
+	}
+}
+
+func TestParsePublicIDs(t *testing.T) {
+	ids, err := parsePublicIDs([]string{"2013p407387", " 2013p407399", "2013p407387"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != "2013p407387" || ids[1] != "2013p407399" {
+		t.Errorf("incorrect publicIDs %v", ids)
+	}
+
+	var many []string
+	for i := 0; i <= maxPublicIDs; i++ {
+		many = append(many, strconv.Itoa(i))
+	}
+
+	for _, bad := range [][]string{nil, {""}, {"2013P407387"}, {"2013p407387;drop"}, many} {
+		if _, err := parsePublicIDs(bad); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
+
+//## Quakes Near a Point
+//
+// **GET /quake?lat=(latitude)&lon=(longitude)&radius=(km)&startTime=(ISO8601)&endTime=(ISO8601)&limit=(n)**
+//
+// Get quakes with an epicenter within radius km of a point ordered by origin time (most recent first).
+// Each quake has an additional `distance` property; the distance in km from the point to the epicenter.
+//
+//### Parameters
+//
+// * `lat` - the latitude of the point.
+// * `lon` - the longitude of the point.
+// * `radius` - the distance from the point in km.  Must be greater than `0` and no more than `1000`.
+// * `startTime` - optional.  The start of the time window as an ISO8601 date time.
+// * `endTime` - optional.  The end of the time window as an ISO8601 date time.
+// * `limit` - optional.  The maximum number of quakes to return.  Must be between `1` and `10000`.
+//
+//### Example request:
+//
+// `/quake?lat=-43.5&lon=172.6&radius=50&limit=100`
+//
+func TestQuakesRadiusV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?lat=-43.4&lon=172.28&radius=1",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Publicid != "2013p407387" {
+		t.Error("incorrect publicid")
+	}
+
+	if !(f.Features[0].Properties.Distance > 0 && f.Features[0].Properties.Distance < 1) {
+		t.Errorf("incorrect distance: %f", f.Features[0].Properties.Distance)
+	}
+
+	// Two of the Kermadec test quakes are within 50 km of a point on the 180 meridian.
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?lat=-37.9&lon=180&radius=50",
+	}
+
+	b, err = c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 2 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	for _, q := range f.Features {
+		if q.Properties.Distance > 50 {
+			t.Errorf("quake %s too far away: %f", q.Properties.Publicid, q.Properties.Distance)
+		}
+	}
+}
+
+//## Filtering Quake Lists
+//
+// Quake lists can be filtered by magnitude, depth, and event type.
+//
+//### Parameters
+//
+// * `minMag` - optional.  The minimum magnitude (inclusive).
+// * `maxMag` - optional.  The maximum magnitude (inclusive).
+// * `minDepth` - optional.  The minimum depth (inclusive) in km.
+// * `maxDepth` - optional.  The maximum depth (inclusive) in km.
+// * `type` - optional.  A comma separated list of event types e.g., `earthquake,landslide`.
+//
+//### Example request:
+//
+// `/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good&minDepth=40&maxDepth=300`
+//
+func TestQuakesFilterV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// There is one deep test quake in the NZ region.
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?regionID=newzealand&intensity=unnoticeable&number=1000&quality=best,caution,good&minDepth=100",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	if f.Features[0].Properties.Depth < 100 {
+		t.Errorf("quake too shallow: %f", f.Features[0].Properties.Depth)
+	}
+
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?startTime=2012-02-04&endTime=2012-02-05&minMag=4.1&maxMag=4.5",
+	}
+
+	b, err = c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 2 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	for _, q := range f.Features {
+		if q.Properties.Magnitude < 4.1 || q.Properties.Magnitude > 4.5 {
+			t.Errorf("quake %s magnitude out of range: %f", q.Properties.Publicid, q.Properties.Magnitude)
+		}
+	}
+
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake?regionID=newzealand&intensity=unnoticeable&number=1000&quality=best,caution,good&type=landslide",
+	}
+
+	b, err = c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 0 {
+		t.Errorf("Found wrong number of features: %d", len(f.Features))
+	}
+}
+
+type QuakeHistoryFeatures struct {
+	Features []QuakeHistoryFeature
+}
+
+type QuakeHistoryFeature struct {
+	Properties QuakeHistoryProperties
+	Geometry   QuakeGeometry
+}
+
+type QuakeHistoryProperties struct {
+	Publicid, Time, Modificationtime, Type, Status, Quality, MagnitudeType string
+	Depth, Magnitude                                                       float64
+}
+
+//## Quake History
+//
+// **GET /quake/history/(publicID)**
+//
+// Get each recorded version of the information for a single quake ordered by modification time (oldest first).
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2014p715167`.
+//
+//### Example request:
+//
+// `/quake/history/2011a440804`
+//
+func TestQuakeHistoryV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/history/2011a440804",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeHistoryFeatures
+
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(f.Features) != 7 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	for i, q := range f.Features {
+		if q.Properties.Publicid != "2011a440804" {
+			t.Errorf("incorrect publicid: %s", q.Properties.Publicid)
+		}
+		if q.Geometry.Type != "Point" {
+			t.Error("wrong type")
+		}
+		if i > 0 && q.Properties.Modificationtime < f.Features[i-1].Properties.Modificationtime {
+			t.Error("history not ordered by modification time")
+		}
+	}
+
+	// The magnitude changed after the first solution.
+	if f.Features[0].Properties.Magnitude == f.Features[6].Properties.Magnitude {
+		t.Error("expected the magnitude to change")
+	}
+}
+
+//## Quake Changes
+//
+// **GET /quake/changes?since=(ISO8601)&limit=(n)**
+//
+// Get quakes that have changed since a time, including deleted quakes, in modification time order (oldest first).
+// Changes made in the last two minutes are held back until a later request so that changes that take time to be saved are not missed.
+// Use this to keep a copy of the quake catalogue up to date.
+//
+//### Parameters
+//
+// * `since` - return changes after this time as an ISO8601 date time e.g., `2013-01-01T00:00:00Z`.  Usually `next` from the previous request.
+// * `limit` - optional, the maximum number of quakes and deletions to return (1 to 10000).  Changes at the same time as the last change are always included.
+//
+// The response is a GeoJSON FeatureCollection with two extra members:
+//
+// * `deleted` - the `publicID` and deletion `time` of quakes that have been removed.
+// * `next` - the cursor to use as `since` for the next request.
+//
+//### Example request:
+//
+// `/quake/changes?since=2013-01-01T00:00:00Z`
+//
+func TestQuakeChangesV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	all := getQuakeChanges(t, "/quake/changes?since=2012-01-01T00:00:00Z")
+
+	// the 2012p quakes and 2013p407387.
+	if len(all.Features) != 7 {
+		t.Errorf("Found wrong number of features: %d", len(all.Features))
+	}
+
+	if len(all.Deleted) != 1 || all.Deleted[0].PublicID != "2012p000999" || all.Deleted[0].Time != "2012-06-01T00:00:00Z" {
+		t.Errorf("expected one deleted quake: %v", all.Deleted)
+	}
+
+	if all.Next != "2013-06-13T23:47:04.344852Z" {
+		t.Errorf("incorrect next: %s", all.Next)
+	}
+
+	// Paging with a limit gets the same changes.
+	var n, deleted int
+	since := "2012-01-01T00:00:00Z"
+
+	for i := 0; i < 20; i++ {
+		f := getQuakeChanges(t, "/quake/changes?limit=2&since="+since)
+
+		if len(f.Features)+len(f.Deleted) == 0 {
+			if f.Next != since {
+				t.Errorf("expected next %s got %s", since, f.Next)
+			}
+			break
+		}
+
+		if f.Next <= since {
+			t.Errorf("next did not increase: %s", f.Next)
+			break
+		}
+
+		n += len(f.Features)
+		deleted += len(f.Deleted)
+		since = f.Next
+	}
+
+	if n != len(all.Features) || deleted != len(all.Deleted) {
+		t.Errorf("paging got %d features and %d deleted, expected %d and %d", n, deleted, len(all.Features), len(all.Deleted))
+	}
+
+	if since != all.Next {
+		t.Errorf("paging got next %s expected %s", since, all.Next)
+	}
+
+	// Nothing has changed since next.
+	f := getQuakeChanges(t, "/quake/changes?since="+all.Next)
+
+	if len(f.Features) != 0 || len(f.Deleted) != 0 {
+		t.Error("expected no changes")
+	}
+
+	if f.Next != all.Next {
+		t.Errorf("incorrect next: %s", f.Next)
+	}
+}
+
+// TestQuakeChangesOutOfOrder deletes a quake in a transaction that commits after a later deletion
+// has committed.  Neither deletion should be served until both are older than changesLag and then
+// both are served so a client following next does not miss the first deletion.
+func TestQuakeChangesOutOfOrder(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// Tombstones are written as hazard_w.
+	d := *config.DataBase
+	d.User = "hazard_w"
+
+	dbw, err := sql.Open("postgres", d.Postgres())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbw.Close()
+
+	defer dbw.Exec(`DELETE FROM qrt.quake_tombstone WHERE publicid IN ('2099p000001', '2099p000002')`)
+
+	lag := changesLag
+	changesLag = 2 * time.Second
+	defer func() { changesLag = lag }()
+
+	var since string
+	if err = dbw.QueryRow(`SELECT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')`).Scan(&since); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := dbw.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	// deletetime is the start of the transaction.
+	if _, err = tx.Exec(`INSERT INTO qrt.quake_tombstone(publicid) VALUES ('2099p000001')`); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err = dbw.Exec(`INSERT INTO qrt.quake_tombstone(publicid) VALUES ('2099p000002')`); err != nil {
+		t.Fatal(err)
+	}
+
+	f := getQuakeChanges(t, "/quake/changes?since="+since)
+
+	if len(f.Deleted) != 0 || f.Next != since {
+		t.Errorf("expected recent changes to be held back: %v next %s", f.Deleted, f.Next)
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(changesLag + time.Second)
+
+	f = getQuakeChanges(t, "/quake/changes?since="+since)
+
+	if len(f.Deleted) != 2 || f.Deleted[0].PublicID != "2099p000001" || f.Deleted[1].PublicID != "2099p000002" {
+		t.Errorf("expected both deletions in deletion time order: %v", f.Deleted)
+	}
+
+	if len(f.Deleted) == 2 && f.Next != f.Deleted[1].Time {
+		t.Errorf("incorrect next: %s", f.Next)
+	}
+}
+
+type quakeChangesV1 struct {
+	Features []QuakeFeature
+	Deleted  []struct {
+		PublicID string
+		Time     string
+	}
+	Next string
+}
+
+// getQuakeChanges gets uri from /quake/changes.
+func getQuakeChanges(t *testing.T, uri string) (f quakeChangesV1) {
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    uri,
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+//## Aftershock Sequence
+//
+// **GET /quake/(publicID)/sequence**
+//
+// Get the quakes after a mainshock within a distance and time window scaled by the mainshock magnitude
+// ([Gardner and Knopoff, 1974](http://www.bssaonline.org/content/64/5/1363)).
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2013p407387`.
+//
+// The response is a GeoJSON FeatureCollection, with a `distance` (km) from the mainshock property for each quake, and a `summary` member:
+//
+// * `mainshock` - the `publicID`, `time`, and `magnitude` of the mainshock.
+// * `window` - the `radius` (km), `days`, and `endTime` of the aftershock window.
+// * `count` - the number of aftershocks.
+// * `largest` - the `publicID`, `time`, and `magnitude` of the largest aftershock or `null`.
+// * `dailyRate` - the number of aftershocks each day (UTC) from the mainshock until the end of the window or now.
+//
+//### Example request:
+//
+// `/quake/2013p407387/sequence`
+//
+func TestQuakeSequenceV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387/sequence",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []QuakeFeature
+		Summary  struct {
+			Mainshock struct {
+				PublicID string
+				Time     string
+			}
+			Window struct {
+				Radius, Days float64
+			}
+			Count     int
+			DailyRate []struct {
+				Date  string
+				Count int
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Summary.Mainshock.PublicID != "2013p407387" || f.Summary.Mainshock.Time != "2013-05-30T15:15:37.812Z" {
+		t.Errorf("incorrect mainshock: %v", f.Summary.Mainshock)
+	}
+
+	if f.Summary.Count != len(f.Features) {
+		t.Errorf("count %d does not match the number of features %d", f.Summary.Count, len(f.Features))
+	}
+
+	if len(f.Summary.DailyRate) == 0 || f.Summary.DailyRate[0].Date != "2013-05-30" {
+		t.Error("expected the daily rate to start on the day of the mainshock")
+	}
+
+	var n int
+	for _, d := range f.Summary.DailyRate {
+		n += d.Count
+	}
+
+	if n != f.Summary.Count {
+		t.Errorf("daily rates sum to %d expected %d", n, f.Summary.Count)
+	}
+
+	for _, q := range f.Features {
+		if q.Properties.Publicid == "2013p407387" {
+			t.Error("the mainshock should not be included")
+		}
+		if q.Properties.Distance > f.Summary.Window.Radius {
+			t.Errorf("quake %s outside the window: %f", q.Properties.Publicid, q.Properties.Distance)
+		}
+	}
+}
+
+func TestGardnerKnopoff(t *testing.T) {
+	in := []struct {
+		m, radius, days float64
+	}{
+		{4.0, 30.07, 41.36},
+		{7.1, 72.77, 924.91},
+	}
+
+	for _, v := range in {
+		r, d := gardnerKnopoff(v.m)
+
+		if math.Abs(r-v.radius) > 0.01 || math.Abs(d-v.days) > 0.01 {
+			t.Errorf("M%.1f: got %.2f km %.2f days expected %.2f km %.2f days", v.m, r, d, v.radius, v.days)
+		}
+	}
+}
+
+//## Intensity at Localities
+//
+// **GET /quake/(publicID)/localities**
+//
+// Get the predicted intensity for a quake at each locality (towns and cities, size 0 to 2), ordered by MMI (highest first).
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2013p407387`.
+//
+//### Locality Properties
+//
+// * `name` - the locality name.
+// * `size` - the locality size; `0` (largest) to `2`.
+// * `distance` - the distance (km) from the quake epicenter to the locality.
+// * `bearing` - the direction of the quake from the locality e.g., `south-east`.
+// * `mmi` - the predicted Modified Mercalli Intensity at the locality.
+// * `intensity` - the predicted intensity at the locality e.g., `weak`.
+//
+//### Example request:
+//
+// `/quake/2013p407387/localities`
+//
+func TestQuakeLocalitiesV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387/localities",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []struct {
+			Geometry   QuakeGeometry
+			Properties struct {
+				Name, Bearing, Intensity string
+				Size                     int
+				Distance, MMI            float64
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) == 0 {
+		t.Fatal("expected some localities")
+	}
+
+	for i, l := range f.Features {
+		p := l.Properties
+
+		if p.Size < 0 || p.Size > 2 {
+			t.Errorf("%s: incorrect size %d", p.Name, p.Size)
+		}
+
+		if p.Bearing == "" || p.Distance <= 0 {
+			t.Errorf("%s: expected a bearing and distance", p.Name)
+		}
+
+		if i > 0 && p.MMI > f.Features[i-1].Properties.MMI {
+			t.Error("localities not ordered by mmi")
+		}
+
+		if l.Geometry.Type != "Point" {
+			t.Error("wrong type")
+		}
+	}
+
+	// Christchurch is about 30 km from the quake so it should have felt it.
+	var found bool
+	for _, l := range f.Features {
+		if l.Properties.Name == "Christchurch" {
+			found = true
+			if l.Properties.Intensity == "unnoticeable" {
+				t.Errorf("expected Christchurch to feel the quake: %f", l.Properties.MMI)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("expected Christchurch in the localities")
+	}
+}
+
+//## Intensity in Quake Regions
+//
+// **GET /quake/(publicID)/regions**
+//
+// Get the calculated intensity for a quake in each quake region, ordered by MMI (highest first).
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2013p407387`.
+//
+//### Region Properties
+//
+// * `regionID` - a unique indentifier for the region.
+// * `title` - the region title.
+// * `group` - the region group.
+// * `mmi` - the calculated Modified Mercalli Intensity in the region.  `-1` if there is no calculated intensity.
+// * `intensity` - the calculated intensity in the region e.g., `weak`.
+//
+//### Example request:
+//
+// `/quake/2013p407387/regions`
+//
+func TestQuakeRegionsV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387/regions",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []struct {
+			Properties struct {
+				RegionID, Title, Group, Intensity string
+				MMI                               float64
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	// there are 11 quake regions in the test data.
+	if len(f.Features) != 11 {
+		t.Fatalf("expected 11 regions got %d", len(f.Features))
+	}
+
+	m := make(map[string]float64)
+
+	for i, v := range f.Features {
+		p := v.Properties
+
+		if p.Title == "" || p.Group == "" || p.Intensity == "" {
+			t.Errorf("%s: expected a title, group, and intensity", p.RegionID)
+		}
+
+		if i > 0 && p.MMI > f.Features[i-1].Properties.MMI {
+			t.Error("regions not ordered by mmi")
+		}
+
+		m[p.RegionID] = p.MMI
+	}
+
+	// The quake is in Canterbury.  The intensity in Canterbury is the intensity in New Zealand.
+	if m["canterbury"] != m["newzealand"] {
+		t.Errorf("expected the same mmi in canterbury and newzealand got %f %f", m["canterbury"], m["newzealand"])
+	}
+
+	if m["canterbury"] < m["aucklandnorthland"] {
+		t.Errorf("expected a higher mmi in canterbury than aucklandnorthland got %f %f", m["canterbury"], m["aucklandnorthland"])
+	}
+}
+
+//## Intensity Contours
+//
+// **GET /quake/(publicID)/contours**
+//
+// Get the predicted intensity for a quake as MultiPolygons for each MMI band with a predicted MMI of at least 3, ordered by MMI.
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2013p407387`.
+//
+//### Properties
+//
+// * `mmi` - the lower bound of the Modified Mercalli Intensity band e.g., `4`.
+// * `intensity` - the intensity for the band e.g., `light`.
+// * `radius` - the distance (km) from the epicenter to the outer edge of the band.
+//
+//### Example request:
+//
+// `/quake/2013p407387/contours`
+//
+func TestQuakeContoursV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387/contours",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []struct {
+			Geometry struct {
+				Type string
+			}
+			Properties struct {
+				MMI, Radius float64
+				Intensity   string
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) == 0 {
+		t.Fatal("expected some MMI bands")
+	}
+
+	for i, v := range f.Features {
+		if v.Geometry.Type != "MultiPolygon" {
+			t.Errorf("wrong geometry type %s", v.Geometry.Type)
+		}
+
+		if v.Properties.MMI < 3 || v.Properties.Radius <= 0 {
+			t.Errorf("incorrect band %f %f", v.Properties.MMI, v.Properties.Radius)
+		}
+
+		if i > 0 && v.Properties.MMI <= f.Features[i-1].Properties.MMI {
+			t.Error("bands not ordered by mmi")
+		}
+	}
+
+	// the second request is from the cache.
+	c2, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != string(c2) {
+		t.Error("cached contours differ")
+	}
+}
+
+func TestContourCache(t *testing.T) {
+	c := contourCache{quakes: make(map[string]contourEntry)}
+
+	t0 := time.Date(2013, 6, 13, 23, 47, 4, 0, time.UTC)
+
+	c.add("2013p407387", t0, []byte("a"))
+
+	if b, ok := c.get("2013p407387", t0); !ok || string(b) != "a" {
+		t.Error("expected contours from the cache")
+	}
+
+	if _, ok := c.get("2013p407387", t0.Add(time.Second)); ok {
+		t.Error("expected no contours for a new updatetime")
+	}
+
+	if _, ok := c.get("2013p407399", t0); ok {
+		t.Error("expected no contours for an unknown quake")
+	}
+
+	for i := 0; i < maxContours; i++ {
+		c.add(strconv.Itoa(i), t0, []byte("b"))
+	}
+
+	if len(c.quakes) > maxContours {
+		t.Errorf("cache has grown to %d", len(c.quakes))
+	}
+}
+
+//## Intensity at a Point
+//
+// **GET /quake/(publicID)/intensity?lat=(lat)&lon=(lon)**
+//
+// Get the predicted intensity for a quake at a point.  The response is a FeatureCollection with a single Feature for the point.
+//
+//### Parameters
+//
+// * `publicID` - a valid quake ID e.g., `2013p407387`.
+// * `lat` - the latitude of the point e.g., `-43.53`.
+// * `lon` - the longitude of the point e.g., `172.63`.  Longitudes from 180 to 360 are also accepted.
+//
+//### Properties
+//
+// * `mmi` - the predicted Modified Mercalli Intensity at the point.  `-1` if there is no predicted intensity.
+// * `intensity` - the predicted intensity at the point e.g., `weak`.
+// * `distance` - the distance (km) from the quake epicenter to the point.
+// * `slantDistance` - the distance (km) from the quake hypocenter to the point.
+//
+//### Example request:
+//
+// `/quake/2013p407387/intensity?lat=-43.53&lon=172.63`
+//
+func TestQuakeIntensityV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387/intensity?lat=-43.53&lon=172.63",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []struct {
+			Geometry   QuakeGeometry
+			Properties struct {
+				Intensity                    string
+				MMI, Distance, SlantDistance float64
+			}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("expected 1 feature got %d", len(f.Features))
+	}
+
+	p := f.Features[0].Properties
+
+	if f.Features[0].Geometry.Type != "Point" {
+		t.Error("wrong type")
+	}
+
+	if f.Features[0].Geometry.Coordinates[0] != 172.63 || f.Features[0].Geometry.Coordinates[1] != -43.53 {
+		t.Errorf("wrong coordinates %v", f.Features[0].Geometry.Coordinates)
+	}
+
+	if p.Distance <= 0 || p.SlantDistance <= p.Distance {
+		t.Errorf("incorrect distances %f %f", p.Distance, p.SlantDistance)
+	}
+
+	if p.MMI <= 0 || p.Intensity == "" || p.Intensity == "unnoticeable" {
+		t.Errorf("expected Christchurch to feel the quake: %f %s", p.MMI, p.Intensity)
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	in := []struct {
+		lat, lon string
+		la, lo   float64
+		ok       bool
+	}{
+		{"-43.53", "172.63", -43.53, 172.63, true},
+		{"-43.53", "190", -43.53, -170, true},
+		{"90", "-180", 90, -180, true},
+		{"-91", "172.63", 0, 0, false},
+		{"-43.53", "361", 0, 0, false},
+		{"bad", "172.63", 0, 0, false},
+		{"-43.53", "", 0, 0, false},
+	}
+
+	for _, v := range in {
+		la, lo, err := parsePoint(v.lat, v.lon)
+		if v.ok != (err == nil) {
+			t.Errorf("%s %s: unexpected error %v", v.lat, v.lon, err)
+			continue
+		}
+
+		if v.ok && (la != v.la || lo != v.lo) {
+			t.Errorf("%s %s: expected %f %f got %f %f", v.lat, v.lon, v.la, v.lo, la, lo)
+		}
+	}
+}
+
+//## Quakes as CSV
+//
+// All quake queries can be returned as CSV by setting the Accept header to `text/csv;version=1`.
+// The first row is a header row.  The columns are the quake properties with the addition of `longitude` and `latitude`.
+//
+//### Example request:
+//
+// `curl -H "Accept: text/csv;version=1" /quake/2013p407387`
+//
+func TestQuakeCSVV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1CSV,
+		URI:    "/quake/2013p407387",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("Found wrong number of rows: %d", len(rows))
+	}
+
+	q := make(map[string]string)
+	for i, h := range rows[0] {
+		q[h] = rows[1][i]
+	}
+
+	if q["publicID"] != "2013p407387" {
+		t.Error("incorrect publicID")
+	}
+
+	if q["longitude"] != "172.28223" {
+		t.Errorf("incorrect longitude: %s", q["longitude"])
+	}
+
+	if q["latitude"] != "-43.397461" {
+		t.Errorf("incorrect latitude: %s", q["latitude"])
+	}
+
+	if q["time"] != "2013-05-30T15:15:37.812Z" {
+		t.Error("incorrect time")
+	}
+
+	if q["locality"] != "15 km south-east of Oxford" {
+		t.Error("incorrect locality")
+	}
+
+	if q["depth"] != "20.141276" {
+		t.Errorf("incorrect depth: %s", q["depth"])
+	}
+}
+
+func TestFeaturesToCSV(t *testing.T) {
+	j := `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[172.28223,-43.397461]},
+	"properties":{"publicID":"2013p407387","magnitude":4.0252561,"locality":"15 km south-east of Oxford","distance":1.5}}]}`
+
+	b, err := featuresToCSV([]byte(j), []string{"publicID", "longitude", "latitude", "magnitude", "locality", "distance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := "publicID,longitude,latitude,magnitude,locality,distance\n2013p407387,172.28223,-43.397461,4.0252561,15 km south-east of Oxford,1.5\n"
+
+	if b.String() != e {
+		t.Errorf("incorrect CSV, expected:\n%s\ngot:\n%s", e, b.String())
+	}
+
+	// the header is the same when there are no features.
+	b, err = featuresToCSV([]byte(`{"type":"FeatureCollection","features":[]}`), csvHeader(quakesRadiusD.Props))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.String() != strings.Join(csvColumns, ",")+",distance\n" {
+		t.Errorf("incorrect CSV header for empty features: %s", b.String())
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	in := []struct {
+		doc *apidoc.Query
+		e   string
+	}{
+		{quakeD, strings.Join(csvColumns, ",")},
+		{quakesTimeD, strings.Join(csvColumns, ",")},
+		{quakesPublicIDD, strings.Join(csvColumns, ",")},
+		{quakeSequenceD, strings.Join(csvColumns, ",") + ",distance"},
+		{quakeLocalitiesD, "longitude,latitude,intensity,bearing,distance,mmi,name,size"},
+	}
+
+	for _, v := range in {
+		if h := strings.Join(csvHeader(v.doc.Props), ","); h != v.e {
+			t.Errorf("%s: expected header %s got %s", v.doc.Title, v.e, h)
+		}
+	}
+}
+
+//## Sparse Fields
+//
+// **GET /quake?(query)&fields=(property,property,...)&precision=(n)**
+//
+// Limit the properties and coordinate decimal places in any of the GeoJSON quake, region, and volcano queries.
+//
+//### Parameters
+//
+// * `fields` - optional.  A comma separated list of the properties to include for each Feature.
+// * `precision` - optional.  The number of decimal places for coordinates.  Must be between `0` and `10`.
+//
+//### Example request:
+//
+// `/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&fields=publicID,time,magnitude&precision=3`
+//
+func TestQuakeFieldsV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387?fields=publicID,magnitude&precision=2",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var f struct {
+		Features []struct {
+			Geometry   QuakeGeometry
+			Properties map[string]interface{}
+		}
+	}
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Features) != 1 {
+		t.Fatalf("Found wrong number of features: %d", len(f.Features))
+	}
+
+	p := f.Features[0].Properties
+
+	if len(p) != 2 || p["publicID"] != "2013p407387" || p["magnitude"] == nil {
+		t.Errorf("incorrect properties %v", p)
+	}
+
+	if g := f.Features[0].Geometry.Coordinates; len(g) != 2 || g[0] != 172.28 || g[1] != -43.4 {
+		t.Errorf("incorrect coordinates %v", g)
+	}
+}
+
+func TestFieldsFilter(t *testing.T) {
+	f := geoJSONFormat{fields: []string{"magnitude", "publicID", "status"}, precision: 3}
+
+	e := `row_to_json((SELECT l FROM (SELECT
+                         		q.publicid as "publicID",
+                         		q.magnitude as "magnitude"
+                         ) as l))`
+
+	if p := f.properties(quakeProps); p != e {
+		t.Errorf("incorrect properties, expected:\n%s\ngot:\n%s", e, p)
+	}
+
+	if g := f.geometry("q.origin_geom"); g != `ST_AsGeoJSON(q.origin_geom, 3)::json` {
+		t.Errorf("incorrect geometry: %s", g)
+	}
+
+	f = geoJSONFormat{fields: []string{"status"}, precision: -1}
+
+	if p := f.properties(quakeProps); p != `'{}'::json` {
+		t.Errorf("expected empty properties got %s", p)
+	}
+
+	if g := f.geometry("q.origin_geom"); g != `ST_AsGeoJSON(q.origin_geom)::json` {
+		t.Errorf("incorrect geometry: %s", g)
+	}
+
+	for _, v := range []string{"fields=publicID,,time", "fields=public-ID", "fields=bad", "fields=next", "precision=-1", "precision=11", "precision=bad"} {
+		r, _ := http.NewRequest("GET", "/quake?startTime=2013-05-30T00:00:00Z&"+v, nil)
+		r = r.WithContext(context.WithValue(r.Context(), mediaTypeKey{}, web.V1GeoJSON))
+
+		err := checkFields(quakesTimeD, r.URL.Query())
+		if err == nil {
+			_, err = newGeoJSONFormat(r)
+		}
+		if err == nil {
+			t.Errorf("expected an error for %s", v)
+		}
+	}
+
+	r, _ := http.NewRequest("GET", "/quake/2013p407387?fields=publicID", nil)
+	r = r.WithContext(context.WithValue(r.Context(), mediaTypeKey{}, web.V1CSV))
+
+	if _, err := newGeoJSONFormat(r); err == nil {
+		t.Error("expected an error for fields with CSV")
+	}
+}
+
+//## Quakes as QuakeML
+//
+// All quake queries (apart from quake history) can be returned as QuakeML 1.2 by setting the Accept header to `application/vnd.quakeml+xml;version=1.2`.
+//
+//### Example request:
+//
+// `curl -H "Accept: application/vnd.quakeml+xml;version=1.2" /quake/2013p407387`
+//
+func TestQuakeQuakeMLV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	c := webtest.Content{
+		Accept: quakeML12,
+		URI:    "/quake/2013p407387",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var q struct {
+		EventParameters eventParameters `xml:"eventParameters"`
+	}
+
+	if err = xml.Unmarshal(b, &q); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(q.EventParameters.Events) != 1 {
+		t.Fatalf("Found wrong number of events: %d", len(q.EventParameters.Events))
+	}
+
+	e := q.EventParameters.Events[0]
+
+	if e.PublicID != "smi:nz.org.geonet/2013p407387" {
+		t.Errorf("incorrect publicID: %s", e.PublicID)
+	}
+
+	if e.Origin.Time.Value != "2013-05-30T15:15:37.812174Z" {
+		t.Errorf("incorrect origin time: %s", e.Origin.Time.Value)
+	}
+
+	if e.Magnitude == nil || e.Magnitude.Type != "M" {
+		t.Error("incorrect magnitude type")
+	}
+}
+
+func TestQuakeMLEvent(t *testing.T) {
+	q := quakeRow{
+		publicID:              "2013p407387",
+		magnitudeType:         "ML",
+		typ:                   "earthquake",
+		status:                "reviewed",
+		agency:                "WEL(GNS_Primary)",
+		originTime:            time.Date(2013, 5, 30, 15, 15, 37, 812000000, time.UTC),
+		updateTime:            time.Date(2013, 6, 13, 23, 47, 4, 344000000, time.UTC),
+		latitude:              -43.397461,
+		longitude:             172.28223,
+		depth:                 20.1,
+		magnitude:             4.02,
+		usedPhaseCount:        31,
+		magnitudeStationCount: -1,
+	}
+
+	e := q.event()
+
+	if e.Origin.EvaluationMode != "manual" || e.Origin.EvaluationStatus != "reviewed" {
+		t.Errorf("incorrect evaluation mode and status: %s %s", e.Origin.EvaluationMode, e.Origin.EvaluationStatus)
+	}
+
+	if e.Origin.Depth == nil || e.Origin.Depth.Value != 20100 {
+		t.Error("expected depth in metres")
+	}
+
+	if e.Origin.Quality == nil || e.Origin.Quality.UsedPhaseCount != 31 {
+		t.Error("incorrect used phase count")
+	}
+
+	if e.Magnitude == nil {
+		t.Fatal("expected a magnitude")
+	}
+
+	if e.Magnitude.StationCount != nil {
+		t.Error("unknown station count should not be included")
+	}
+
+	if e.PreferredMagnitudeID != e.Magnitude.PublicID || e.PreferredOriginID != e.Origin.PublicID {
+		t.Error("incorrect preferred IDs")
+	}
+
+	// unknown depth and magnitude are not included.
+	q.depth = -9.0
+	q.magnitude = -9.0
+	q.status = "automatic"
+
+	e = q.event()
+
+	if e.Origin.Depth != nil || e.Magnitude != nil || e.PreferredMagnitudeID != "" {
+		t.Error("unknown depth and magnitude should not be included")
+	}
+
+	if e.Origin.EvaluationMode != "automatic" || e.Origin.EvaluationStatus != "preliminary" {
+		t.Errorf("incorrect evaluation mode and status: %s %s", e.Origin.EvaluationMode, e.Origin.EvaluationStatus)
+	}
+
+	b, err := xml.Marshal(quakeML{EventParameters: eventParameters{Events: []qmlEvent{e}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), "<q:quakeml") {
+		t.Errorf("missing quakeml root element: %s", b)
+	}
+}
+
+//## Quake Stream
+//
+// **GET /quake/stream?regionID=(region)&intensity=(intensity)**
+//
+// A [Server-Sent Events](http://www.w3.org/TR/eventsource/) stream of quakes as they are inserted, updated, or deleted.
+// The `Accept` header is ignored.
+//
+//### Parameters
+//
+// * `regionID` - optional, only stream quakes in this quake region e.g., `newzealand`.
+// * `intensity` - optional, only stream quakes with at least this intensity at the epicenter e.g., `weak`.
+//
+// Each `quake` event is a GeoJSON Feature with the same properties as for a single quake.
+// If a quake is removed a `delete` event is sent with a Feature that has a `null` geometry and only the `publicID` property.
+//
+//### Example request:
+//
+// `/quake/stream?regionID=newzealand&intensity=weak`
+//
+func TestQuakeStreamV1(t *testing.T) {
+	setup()
+	defer teardown()
+
+	res, err := http.Get(ts.URL + "/quake/stream?regionID=newzealand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("wrong response code: %d", res.StatusCode)
+	}
+
+	if res.Header.Get("Content-Type") != eventStream {
+		t.Errorf("incorrect Content-Type: %s", res.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(res.Body)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+		close(lines)
+	}()
+
+	// next returns the next event name and data.
+	next := func() (name, data string) {
+		for {
+			select {
+			case l, ok := <-lines:
+				if !ok {
+					t.Fatal("stream closed")
+				}
+				switch {
+				case strings.HasPrefix(l, "event: "):
+					name = l[7:]
+				case strings.HasPrefix(l, "data: "):
+					return name, l[6:]
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for an event")
+			}
+		}
+	}
+
+	if _, err = db.Exec("select pg_notify('quake', '2013p407387')"); err != nil {
+		t.Fatal(err)
+	}
+
+	name, data := next()
+
+	if name != "quake" {
+		t.Errorf("expected a quake event got %s", name)
+	}
+
+	var f QuakeFeature
+
+	if err = json.Unmarshal([]byte(data), &f); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Properties.Publicid != "2013p407387" {
+		t.Errorf("incorrect publicID: %s", f.Properties.Publicid)
+	}
+
+	if _, err = db.Exec("select pg_notify('quake', '2013p407399')"); err != nil {
+		t.Fatal(err)
+	}
+
+	name, data = next()
+
+	if name != "delete" {
+		t.Errorf("expected a delete event got %s", name)
+	}
+
+	if data != `{"type":"Feature","geometry":null,"properties":{"publicID":"2013p407399"}}` {
+		t.Errorf("incorrect delete data: %s", data)
+	}
+}
+
+func TestStreamClientMatch(t *testing.T) {
+	c := streamClient{regionID: "canterbury", intensity: "weak"}
+
+	in := []struct {
+		e     streamEvent
+		match bool
+	}{
+		{streamEvent{name: "quake", intensity: "weak", regions: []string{"newzealand", "canterbury"}}, true},
+		{streamEvent{name: "quake", intensity: "strong", regions: []string{"newzealand", "canterbury"}}, true},
+		{streamEvent{name: "quake", intensity: "unnoticeable", regions: []string{"newzealand", "canterbury"}}, false},
+		{streamEvent{name: "quake", intensity: "light", regions: []string{"newzealand", "wellington"}}, false},
+		{streamEvent{name: "delete"}, true},
+		{resyncEvent, true},
+	}
+
+	for i, v := range in {
+		if c.match(v.e) != v.match {
+			t.Errorf("%d: expected match %t", i, v.match)
+		}
+	}
+
+	// all intensities and regions.
+	c = streamClient{}
+	if !c.match(streamEvent{name: "quake", intensity: "unnoticeable", regions: []string{"newzealand"}}) {
+		t.Error("expected a match for a client with no filters")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"strings"
+)
+
+// quakeV2D is the discussion for the version 2 docs of the quake queries.  There are version 2 docs
+// for each route that is available as version 2, see v2Doc.
+const quakeV2D = `<p>This is version 2 of the GeoJSON for the query.  Set the <code>Accept</code> header to
+	<code>application/vnd.geo+json;version=2</code>.  Requests without a version in the <code>Accept</code> header
+	are served version 1.  The query parameters are the same as for version 1.
+	The differences from version 1 are:</p>
+	<ul>
+	<li>Each Feature has an <code>id</code> that is the quake <code>publicID</code>.</li>
+	<li>The FeatureCollection has a <code>bbox</code> (<code>minLon, minLat, minDepth, maxLon, maxLat, maxDepth</code>) if it has any Features.</li>
+	<li>The coordinates are <code>longitude, latitude, depth</code> with depth in km (positive down).</li>
+	<li>Times are ISO8601 with an offset from UTC e.g., <code>2013-05-30T15:15:37.812+00:00</code>.</li>
+	<li>There are additional quake properties, they are marked <b>Version 2 only</b>.</li>
+	</ul>`
+
+// v2Doc returns the version 2 docs for the quake query d.
+func v2Doc(d *apidoc.Query) *apidoc.Query {
+	v2 := *d
+	v2.Accept = quakeV2GeoJSON
+	v2.Title = d.Title + " - Version 2"
+	v2.Discussion = quakeV2D
+
+	return &v2
+}
+
+// v2OnlyD starts the docs for quake properties that are only in version 2.
+const v2OnlyD = `<b>Version 2 only.</b>  `
+
+// v2Only returns true if the property docs d are for a property that is only in version 2.
+func v2Only(d template.HTML) bool {
+	return strings.HasPrefix(string(d), v2OnlyD)
+}
+
+// withoutV2 returns the property docs props without the properties that are only in version 2.
+func withoutV2(props map[string]template.HTML) map[string]template.HTML {
+	m := make(map[string]template.HTML)
+
+	for k, v := range props {
+		if !v2Only(v) {
+			m[k] = v
+		}
+	}
+
+	return m
+}
+
+// quakeFeature returns the SQL select list for a quake Feature from qrt.quake_materialized (as q) with props.
+// A version 2 Feature has an id and the coordinates have the depth.  It also has the 3D point for the
+// bbox which featureArray leaves out of the Feature JSON.
+func (gf geoJSONFormat) quakeFeature(props []featureProp) string {
+	if !gf.v2 {
+		return `'Feature' as type,
+                         ` + gf.geometry("q.origin_geom") + ` as geometry,
+                         ` + gf.properties(props) + ` as properties`
+	}
+
+	return `'Feature' as type,
+                         q.publicid as id,
+                         ` + gf.geometry("ST_SetSRID(ST_MakePoint(ST_X(q.origin_geom), ST_Y(q.origin_geom), q.depth), 4326)") + ` as geometry,
+                         ` + gf.properties(props) + ` as properties,
+                         ST_MakePoint(ST_X(q.origin_geom), ST_Y(q.origin_geom), q.depth) as point`
+}
+
+// featureArray returns an SQL expression for the JSON array of the quake Features f.  It is null if
+// there are no Features.
+func (gf geoJSONFormat) featureArray() string {
+	if !gf.v2 {
+		return `array_to_json(array_agg(f))`
+	}
+
+	return `array_to_json(array_agg((SELECT x FROM (SELECT f.type, f.id, f.geometry, f.properties) as x)))`
+}
+
+// bbox returns an SQL select list entry for the version 2 bbox of the Features f.  The bbox is the
+// 3D extent of the Feature points; minLon, minLat, minDepth, maxLon, maxLat, maxDepth.  It is null
+// if there are no Features.  Returns an empty string for version 1.
+func (gf geoJSONFormat) bbox() string {
+	if !gf.v2 {
+		return ""
+	}
+
+	return `,
+                         (SELECT CASE WHEN x.e IS NOT NULL THEN array_to_json(ARRAY[ST_XMin(x.e), ST_YMin(x.e), ST_ZMin(x.e),
+                         	ST_XMax(x.e), ST_YMax(x.e), ST_ZMax(x.e)]) END
+                         FROM (SELECT ST_3DExtent(f.point) as e) as x) as bbox`
+}
+
+// collection returns an SQL expression for the JSON of the FeatureCollection row fc.  The bbox member
+// of a version 2 FeatureCollection is removed when it is null.  This only happens when there are no
+// Features so the JSON is small.  A null bbox member can't be in the Features; a "bbox" in a property
+// string is escaped.
+func (gf geoJSONFormat) collection() string {
+	if !gf.v2 {
+		return `row_to_json(fc)`
+	}
+
+	return `CASE WHEN fc.bbox IS NULL THEN replace(row_to_json(fc)::text, ',"bbox":null', '')::json ELSE row_to_json(fc) END`
+}
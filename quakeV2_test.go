@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/webtest"
+	"strings"
+	"testing"
+)
+
+type QuakeV2Features struct {
+	Features []QuakeV2Feature
+	BBox     []float64
+}
+
+type QuakeV2Feature struct {
+	ID         string
+	Geometry   QuakeGeometry
+	Properties map[string]interface{}
+}
+
+//## Quake Version 2
+//
+// Request `Accept: application/vnd.geo+json;version=2` for quakes with:
+//
+// * an `id` for each Feature that is the quake `publicID`.
+// * a `bbox` for the FeatureCollection.
+// * coordinates of longitude, latitude, and depth (km).
+// * times with an offset from UTC e.g., `2013-05-30T15:15:37.812+00:00`.
+//
+// and the additional properties:
+//
+// * `magnitudeType` - the type of the summary magnitude e.g., `ML` or `Mw`.
+// * `usedPhaseCount` - the number of phases used to locate the quake.
+// * `magnitudeStationCount` - the number of stations used for the summary magnitude.
+// * `status` - the status of the quake location e.g., `automatic`, `reviewed`, `deleted`.
+//
+func TestQuakeV2(t *testing.T) {
+	setup()
+	defer teardown()
+
+	for _, u := range []string{
+		"/quake/2013p407387",
+		"/quake?publicID=2013p407387",
+		"/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z",
+	} {
+		c := webtest.Content{
+			Accept: quakeV2GeoJSON,
+			URI:    u,
+		}
+
+		b, err := c.Get(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var f QuakeV2Features
+
+		if err = json.Unmarshal(b, &f); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(f.Features) != 1 {
+			t.Fatalf("Found wrong number of features for %s: %d", u, len(f.Features))
+		}
+
+		p := f.Features[0].Properties
+
+		if p["publicID"] != "2013p407387" || f.Features[0].ID != "2013p407387" {
+			t.Errorf("incorrect publicID or id for %s", u)
+		}
+
+		if p["time"] != "2013-05-30T15:15:37.812+00:00" {
+			t.Errorf("incorrect time for %s: %v", u, p["time"])
+		}
+
+		xyz := f.Features[0].Geometry.Coordinates
+		if len(xyz) != 3 || xyz[2] != p["depth"] {
+			t.Errorf("expected depth as the third coordinate for %s: %v", u, xyz)
+		}
+
+		if len(f.BBox) != 6 || f.BBox[0] != xyz[0] || f.BBox[3] != xyz[0] || f.BBox[2] != xyz[2] {
+			t.Errorf("incorrect bbox for %s: %v", u, f.BBox)
+		}
+
+		if p["magnitudeType"] != "M" {
+			t.Errorf("incorrect magnitudeType for %s: %v", u, p["magnitudeType"])
+		}
+
+		if p["usedPhaseCount"] != 40.0 {
+			t.Errorf("incorrect usedPhaseCount for %s: %v", u, p["usedPhaseCount"])
+		}
+
+		if p["magnitudeStationCount"] != 15.0 {
+			t.Errorf("incorrect magnitudeStationCount for %s: %v", u, p["magnitudeStationCount"])
+		}
+
+		if p["status"] != "automatic" {
+			t.Errorf("incorrect status for %s: %v", u, p["status"])
+		}
+	}
+
+	// changes are in modification time order so find the quake.
+	c := webtest.Content{
+		Accept: quakeV2GeoJSON,
+		URI:    "/quake/changes?since=2012-01-01T00:00:00Z",
+	}
+
+	b, err := c.Get(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ch QuakeV2Features
+
+	if err = json.Unmarshal(b, &ch); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ch.BBox) != 6 {
+		t.Errorf("incorrect bbox for changes: %v", ch.BBox)
+	}
+
+	var found bool
+
+	for _, v := range ch.Features {
+		if v.ID != "2013p407387" {
+			continue
+		}
+		found = true
+
+		if v.Properties["time"] != "2013-05-30T15:15:37.812+00:00" || len(v.Geometry.Coordinates) != 3 {
+			t.Errorf("incorrect version 2 change for 2013p407387: %v %v", v.Properties["time"], v.Geometry.Coordinates)
+		}
+	}
+
+	if !found {
+		t.Error("didn't find 2013p407387 in changes")
+	}
+
+	// version 1 is unchanged.
+	c = webtest.Content{
+		Accept: web.V1GeoJSON,
+		URI:    "/quake/2013p407387",
+	}
+
+	if b, err = c.Get(ts); err != nil {
+		t.Fatal(err)
+	}
+
+	var f QuakeV2Features
+
+	if err = json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"magnitudeType", "usedPhaseCount", "magnitudeStationCount", "status"} {
+		if _, ok := f.Features[0].Properties[k]; ok {
+			t.Errorf("unexpected version 1 property %s", k)
+		}
+	}
+}
+
+func TestQuakeV2SQL(t *testing.T) {
+	v1 := geoJSONFormat{precision: -1}
+	v2 := geoJSONFormat{v2: true, precision: 3}
+
+	if f := v1.quakeFeature(quakeProps); strings.Contains(f, " as id") || strings.Contains(f, "magnitudeType") ||
+		!strings.Contains(f, `'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"'`) {
+		t.Errorf("unexpected version 1 Feature:\n%s", f)
+	}
+
+	f := v2.quakeFeature(quakeProps)
+
+	for _, e := range []string{
+		"q.publicid as id",
+		"ST_AsGeoJSON(ST_SetSRID(ST_MakePoint(ST_X(q.origin_geom), ST_Y(q.origin_geom), q.depth), 4326), 3)",
+		`to_char(q.origintime AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS') || '+00:00' as "time"`,
+		`to_char(q.updatetime AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS') || '+00:00' as "modificationTime"`,
+		`q.magnitudetype as "magnitudeType"`,
+	} {
+		if !strings.Contains(f, e) {
+			t.Errorf("expected %s in version 2 Feature:\n%s", e, f)
+		}
+	}
+
+	if v1.bbox() != "" || v1.collection() != "row_to_json(fc)" || v1.featureArray() != "array_to_json(array_agg(f))" {
+		t.Error("expected no bbox for version 1")
+	}
+
+	// The extent is found once from the quake points.
+	if b := v2.bbox(); strings.Count(b, "ST_3DExtent(f.point)") != 1 || !strings.Contains(b, "array_to_json(ARRAY[") {
+		t.Errorf("expected the bbox from the 3D extent of the Features:\n%s", b)
+	}
+
+	if !strings.Contains(f, "q.depth) as point") || strings.Contains(v2.featureArray(), "f.point") {
+		t.Errorf("expected the bbox point to be left out of the Features:\n%s", v2.featureArray())
+	}
+
+	// Functions and operators that need Postgres newer than 9.3.
+	for _, e := range []string{"TZH", "json_build_array", "jsonb", "json_strip_nulls"} {
+		for _, s := range []string{f, v2.bbox(), v2.collection(), v2.featureArray()} {
+			if strings.Contains(s, e) {
+				t.Errorf("found %s in version 2 SQL:\n%s", e, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// quakeML12 is the media type for QuakeML 1.2.
+const quakeML12 = "application/vnd.quakeml+xml;version=1.2"
+
+// smi is the prefix for QuakeML resource identifiers.
+const smi = "smi:nz.org.geonet/"
+
+// /quake/2013p407387 with Accept: application/vnd.quakeml+xml;version=1.2
+
+var quakeQuakeMLD = &apidoc.Query{
+	Accept:      quakeML12,
+	Title:       "Quake - QuakeML",
+	Description: "Information for a single quake as QuakeML 1.2.",
+	Discussion: `<p>Returns <a href="https://quake.ethz.ch/quakeml/">QuakeML 1.2</a> with an event, 
+	its preferred origin, and its preferred magnitude.  Depths are in metres.</p>`,
+	URI: "/quake/(publicID)",
+	Params: map[string]template.HTML{
+		"publicID": `a valid quake ID e.g., <code>2014p715167</code>`,
+	},
+	Props: quakeMLD,
+}
+
+// /quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z with Accept: application/vnd.quakeml+xml;version=1.2
+
+var quakesQuakeMLD = &apidoc.Query{
+	Accept:      quakeML12,
+	Title:       "Quakes - QuakeML",
+	Description: "Quake lists as QuakeML 1.2.",
+	Discussion: `<p>All of the quake list queries can be returned as <a href="https://quake.ethz.ch/quakeml/">QuakeML 1.2</a> 
+	by setting the <code>Accept</code> header.  The query parameters are the same as for the GeoJSON version of the query 
+	and the events are in the same order.</p>
+	<pre>curl -H "Accept: application/vnd.quakeml+xml;version=1.2" "http://...API-HOST.../quake?startTime=2013-05-30T00:00:00Z&amp;endTime=2013-05-31T00:00:00Z"</pre>`,
+	URI: "/quake?(query)",
+	Params: map[string]template.HTML{
+		"query": `the query parameters for any of the GeoJSON quake list queries.`,
+	},
+	Props: quakeMLD,
+}
+
+var quakeMLD = map[string]template.HTML{
+	`event/type`:                    `the event type; earthquake, landslide etc.`,
+	`origin/time`:                   `the origin time of the quake.`,
+	`origin/latitude`:               `the latitude of the quake.`,
+	`origin/longitude`:              `the longitude of the quake.`,
+	`origin/depth`:                  `the depth of the quake in <b>metres</b>.`,
+	`origin/quality/usedPhaseCount`: `the number of phases used to locate the quake.`,
+	`origin/evaluationMode`:         `<code>automatic</code> or <code>manual</code>.`,
+	`origin/evaluationStatus`:       `<code>preliminary</code>, <code>confirmed</code>, <code>reviewed</code>, or <code>rejected</code> (deleted or duplicate quakes).`,
+	`magnitude/mag`:                 `the summary magnitude for the quake.  This is <b>not</b> Richter magnitude.`,
+	`magnitude/type`:                `the type of the summary magnitude e.g., <code>ML</code>.`,
+	`magnitude/stationCount`:        `the number of stations used to calculate the magnitude.`,
+}
+
+type quakeML struct {
+	XMLName         xml.Name        `xml:"q:quakeml"`
+	Q               string          `xml:"xmlns:q,attr"`
+	NS              string          `xml:"xmlns,attr"`
+	EventParameters eventParameters `xml:"eventParameters"`
+}
+
+type eventParameters struct {
+	PublicID string     `xml:"publicID,attr"`
+	Events   []qmlEvent `xml:"event"`
+}
+
+type qmlEvent struct {
+	PublicID             string        `xml:"publicID,attr"`
+	PreferredOriginID    string        `xml:"preferredOriginID"`
+	PreferredMagnitudeID string        `xml:"preferredMagnitudeID,omitempty"`
+	Type                 string        `xml:"type,omitempty"`
+	CreationInfo         creationInfo  `xml:"creationInfo"`
+	Origin               qmlOrigin     `xml:"origin"`
+	Magnitude            *qmlMagnitude `xml:"magnitude,omitempty"`
+}
+
+type creationInfo struct {
+	AgencyID     string `xml:"agencyID"`
+	CreationTime string `xml:"creationTime"`
+}
+
+type qmlOrigin struct {
+	PublicID         string        `xml:"publicID,attr"`
+	Time             timeQuantity  `xml:"time"`
+	Latitude         realQuantity  `xml:"latitude"`
+	Longitude        realQuantity  `xml:"longitude"`
+	Depth            *realQuantity `xml:"depth,omitempty"`
+	Quality          *originQual   `xml:"quality,omitempty"`
+	EvaluationMode   string        `xml:"evaluationMode"`
+	EvaluationStatus string        `xml:"evaluationStatus"`
+	CreationInfo     creationInfo  `xml:"creationInfo"`
+}
+
+type originQual struct {
+	UsedPhaseCount int `xml:"usedPhaseCount"`
+}
+
+type qmlMagnitude struct {
+	PublicID     string       `xml:"publicID,attr"`
+	Mag          realQuantity `xml:"mag"`
+	Type         string       `xml:"type"`
+	OriginID     string       `xml:"originID"`
+	StationCount *int         `xml:"stationCount,omitempty"`
+	CreationInfo creationInfo `xml:"creationInfo"`
+}
+
+type realQuantity struct {
+	Value float64 `xml:"value"`
+}
+
+type timeQuantity struct {
+	Value string `xml:"value"`
+}
+
+// geoJSONToQuakeML calls h and converts the quakes in the GeoJSON FeatureCollection that it writes to QuakeML.
+// Error responses from h are written to w unchanged.
+func geoJSONToQuakeML(w http.ResponseWriter, r *http.Request, doc *apidoc.Query, h http.HandlerFunc) {
+	j, ok := captureGeoJSON(w, r, h)
+	if !ok {
+		return
+	}
+
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				PublicID string
+			}
+		}
+	}
+
+	if err := json.Unmarshal(j, &fc); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	var ids []string
+	for _, f := range fc.Features {
+		ids = append(ids, f.Properties.PublicID)
+	}
+
+	q, err := quakeMLEvents(ids)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+
+	if err := xml.NewEncoder(&b).Encode(q); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// h has already counted the response in the metrics.
+	b.WriteTo(w)
+}
+
+// newQuakeML returns a QuakeML document with no events.
+func newQuakeML() quakeML {
+	return quakeML{
+		Q:  "http://quakeml.org/xmlns/quakeml/1.2",
+		NS: "http://quakeml.org/xmlns/bed/1.2",
+		EventParameters: eventParameters{
+			PublicID: smi + "eventParameters",
+		},
+	}
+}
+
+// quakeMLEvents returns QuakeML for the quakes with publicIDs in ids.  The events are in the same order as ids.
+func quakeMLEvents(ids []string) (q quakeML, err error) {
+	q = newQuakeML()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	p := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i := range ids {
+		p[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = ids[i]
+	}
+
+	rows, err := db.Query(`SELECT publicid, origintime, latitude, longitude, depth, magnitude, magnitudetype, 
+		usedphasecount, magnitudestationcount, type, status, agency, updatetime
+		FROM qrt.event where publicid in (`+strings.Join(p, ",")+`)`, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	events := make(map[string]qmlEvent)
+
+	for rows.Next() {
+		var e quakeRow
+
+		err = rows.Scan(&e.publicID, &e.originTime, &e.latitude, &e.longitude, &e.depth, &e.magnitude, &e.magnitudeType,
+			&e.usedPhaseCount, &e.magnitudeStationCount, &e.typ, &e.status, &e.agency, &e.updateTime)
+		if err != nil {
+			return
+		}
+
+		events[e.publicID] = e.event()
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		if e, ok := events[id]; ok {
+			q.EventParameters.Events = append(q.EventParameters.Events, e)
+		}
+	}
+
+	return
+}
+
+// quakeRow holds a row from qrt.event.  locality is only set for rows from qrt.quake_materialized.
+type quakeRow struct {
+	publicID, magnitudeType, typ, status, agency string
+	locality                                     string
+	originTime, updateTime                       time.Time
+	latitude, longitude, depth, magnitude        float64
+	usedPhaseCount, magnitudeStationCount        int
+}
+
+// event returns the QuakeML event for q.  -9 is the unknown value for depth and magnitude and
+// -1 is the unknown value for the phase and station counts.  Unknown values are not included.
+func (q quakeRow) event() qmlEvent {
+	id := smi + q.publicID
+	mode, status := evaluation(q.status)
+
+	c := creationInfo{
+		AgencyID:     q.agency,
+		CreationTime: q.updateTime.UTC().Format(time.RFC3339Nano),
+	}
+
+	e := qmlEvent{
+		PublicID:          id,
+		PreferredOriginID: id + "/origin",
+		Type:              q.typ,
+		CreationInfo:      c,
+		Origin: qmlOrigin{
+			PublicID:         id + "/origin",
+			Time:             timeQuantity{Value: q.originTime.UTC().Format(time.RFC3339Nano)},
+			Latitude:         realQuantity{Value: q.latitude},
+			Longitude:        realQuantity{Value: q.longitude},
+			EvaluationMode:   mode,
+			EvaluationStatus: status,
+			CreationInfo:     c,
+		},
+	}
+
+	if q.depth != -9.0 {
+		e.Origin.Depth = &realQuantity{Value: q.depth * 1000.0}
+	}
+
+	if q.usedPhaseCount != -1 {
+		e.Origin.Quality = &originQual{UsedPhaseCount: q.usedPhaseCount}
+	}
+
+	if q.magnitude != -9.0 {
+		e.PreferredMagnitudeID = id + "/magnitude"
+		e.Magnitude = &qmlMagnitude{
+			PublicID:     id + "/magnitude",
+			Mag:          realQuantity{Value: q.magnitude},
+			Type:         q.magnitudeType,
+			OriginID:     id + "/origin",
+			CreationInfo: c,
+		}
+
+		if q.magnitudeStationCount != -1 {
+			n := q.magnitudeStationCount
+			e.Magnitude.StationCount = &n
+		}
+	}
+
+	return e
+}
+
+// evaluation maps the quake status to a QuakeML evaluation mode and status.
+func evaluation(status string) (mode, s string) {
+	switch status {
+	case "reviewed":
+		return "manual", "reviewed"
+	case "confirmed":
+		return "manual", "confirmed"
+	case "deleted", "duplicate":
+		return "manual", "rejected"
+	}
+
+	return "automatic", "preliminary"
+}
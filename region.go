@@ -6,6 +6,7 @@ import (
 	"github.com/GeoNet/web/api/apidoc"
 	"html/template"
 	"net/http"
+	"strings"
 )
 
 // These constants are the length of parts of the URI and are used for
@@ -16,20 +17,21 @@ const (
 
 var regionDoc = apidoc.Endpoint{
 	Title:       "Region",
-	Description: `Look up region information.`,
-	Queries: []*apidoc.Query{
-		regionsD,
-		regionD,
-	},
+	Description: `Look up region information.  The <code>fields</code> and <code>precision</code> query parameters can be used to limit the properties and coordinate decimal places in the response, see <a href="/api-docs/endpoint/quake">quake</a>.`,
+}
+
+// regionProps are the properties, in order, for a region Feature from qrt.region (as q).
+var regionProps = []featureProp{
+	{name: "regionID", sql: `q.regionname`},
+	{name: "title", sql: `q.title`},
+	{name: "group", sql: `q.groupname`},
 }
 
 var regionsD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Regions",
 	Description: "Retrieve regions.",
 	Example:     "/region?type=quake",
 	ExampleHost: exHost,
-	URI:         "/region?type=(type)",
 	Required: map[string]template.HTML{
 		"type": `the region type.  The only allowable value is <code>quake</code>.`,
 	},
@@ -42,30 +44,25 @@ var regionsD = &apidoc.Query{
 
 // just quake regions at the moment.
 func regions(w http.ResponseWriter, r *http.Request) {
-	if err := regionsD.CheckParams(r.URL.Query()); err != nil {
-		web.BadRequest(w, r, err.Error())
+	if r.URL.Query().Get("type") != "quake" {
+		web.BadRequest(w, r, "type must be quake.")
 		return
 	}
 
-	if r.URL.Query().Get("type") != "quake" {
-		web.BadRequest(w, r, "type must be quake.")
+	gf, err := newGeoJSONFormat(r)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
 		return
 	}
 
 	var d string
 
-	err := db.QueryRow(`SELECT row_to_json(fc)
+	err = db.QueryRow(`SELECT row_to_json(fc)
                          FROM ( SELECT 'FeatureCollection' as type, array_to_json(array_agg(f)) as features
                          FROM (SELECT 'Feature' as type,
-                         ST_AsGeoJSON(q.geom)::json as geometry,
-                         row_to_json((SELECT l FROM
-                         	(
-                         		SELECT
-                         		regionname as "regionID",
-                         		title,
-                         		groupname as group
-                           ) as l
-                         )) as properties FROM qrt.region as q where groupname in ('region', 'north', 'south')) as f ) as fc`).Scan(&d)
+                         ` + gf.geometry("q.geom") + ` as geometry,
+                         ` + gf.properties(regionProps) + ` as properties
+                         FROM qrt.region as q where groupname in ('region', 'north', 'south')) as f ) as fc`).Scan(&d)
 
 	if err != nil {
 		web.ServiceUnavailable(w, r, err)
@@ -80,13 +77,11 @@ func regions(w http.ResponseWriter, r *http.Request) {
 // /region/wellington
 
 var regionD = &apidoc.Query{
-	Accept:      web.V1GeoJSON,
 	Title:       "Region",
 	Description: "Retrieve a single region.",
 	Example:     "/region/wellington",
 	ExampleHost: exHost,
-	URI:         "/region/(regionID)",
-	Required: map[string]template.HTML{
+	Params: map[string]template.HTML{
 		"regionID": `A region ID e.g., <code>wellington</code>.`,
 	},
 	Props: map[string]template.HTML{
@@ -97,16 +92,17 @@ var regionD = &apidoc.Query{
 }
 
 func region(w http.ResponseWriter, r *http.Request) {
-	if len(r.URL.Query()) != 0 {
-		web.BadRequest(w, r, "incorrect number of query parameters.")
+	regionID := r.URL.Path[regionLen:]
+
+	gf, err := newGeoJSONFormat(r)
+	if err != nil {
+		web.BadRequest(w, r, err.Error())
 		return
 	}
 
-	regionID := r.URL.Path[regionLen:]
-
 	var d string
 
-	err := db.QueryRow("select regionname FROM qrt.region where regionname = $1", regionID).Scan(&d)
+	err = db.QueryRow("select regionname FROM qrt.region where regionname = $1", regionID).Scan(&d)
 	if err == sql.ErrNoRows {
 		web.BadRequest(w, r, "invalid regionID: "+regionID)
 		return
@@ -119,21 +115,80 @@ func region(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow(`SELECT row_to_json(fc)
                          FROM ( SELECT 'FeatureCollection' as type, array_to_json(array_agg(f)) as features
                          FROM (SELECT 'Feature' as type,
-                         ST_AsGeoJSON(q.geom)::json as geometry,
-                         row_to_json((SELECT l FROM 
+                         `+gf.geometry("q.geom")+` as geometry,
+                         `+gf.properties(regionProps)+` as properties
+                         FROM qrt.region as q where regionname = $1 ) as f ) as fc`, regionID).Scan(&d)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	w.Header().Set("Surrogate-Control", web.MaxAge86400)
+	b := []byte(d)
+	web.Ok(w, r, &b)
+}
+
+// /quake/2013p407387/regions
+
+var quakeRegionsD = &apidoc.Query{
+	Title:       "Intensity in Quake Regions",
+	Description: "the calculated intensity for a quake in each quake region, ordered by MMI (highest first).",
+	Discussion: `<p>The intensity in a region is the calculated intensity at the closest locality in the region, the same as
+	<code>regionIntensity</code>.  There is a Feature, with no geometry, for each quake region.  The region geometry is available
+	from <code>/region/(regionID)</code>.  If the quake depth or magnitude is not known then the <code>mmi</code> is <code>-1</code>.</p>`,
+	Example:     "/quake/2013p407387/regions",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		`publicID`: `a valid quake ID e.g., <code>2013p407387</code>.`,
+	},
+	Props: map[string]template.HTML{
+		`regionID`:  `a unique indentifier for the region.`,
+		`title`:     `the region title.`,
+		`group`:     `the region group.`,
+		`mmi`:       `the calculated <a href="http://info.geonet.org.nz/x/w4IO">Modified Mercalli Intensity (MMI)</a> in the region.  <code>-1</code> if there is no calculated intensity.`,
+		`intensity`: `the calculated <a href="http://info.geonet.org.nz/x/b4Ih">intensity</a> in the region e.g., <code>weak</code>.`,
+	},
+}
+
+func quakeRegions(w http.ResponseWriter, r *http.Request) {
+	publicID := strings.TrimSuffix(r.URL.Path[quakeLen:], "/regions")
+
+	var d string
+
+	err := db.QueryRow("select publicid FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&d)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	err = db.QueryRow(
+		`SELECT row_to_json(fc)
+                         FROM ( SELECT 'FeatureCollection' as type, COALESCE(array_to_json(array_agg(f)), '[]') as features
+                         FROM (SELECT 'Feature' as type,
+                         NULL::json as geometry,
+                         row_to_json((SELECT l FROM
                          	(
-                         		SELECT 
+                         		SELECT
                          		regionname as "regionID",
-                         		title, 
-                         		groupname as group
+                         		title,
+                         		groupname as group,
+                         		mmi,
+                         		qrt.mmi_to_intensity(mmi::double precision) as intensity
                            ) as l
-                         )) as properties FROM qrt.region as q where regionname = $1 ) as f ) as fc`, regionID).Scan(&d)
+                         )) as properties
+                         FROM (SELECT r.regionname, r.title, r.groupname, COALESCE(m.mmi, -1.0) as mmi
+                         	FROM qrt.region as r LEFT JOIN qrt.quake_region_mmi as m ON m.regionname = r.regionname AND m.publicid = $1
+                         	WHERE r.groupname in ('region', 'north', 'south')) as q
+                         ORDER BY mmi DESC, regionname ) as f ) as fc`, publicID).Scan(&d)
 	if err != nil {
 		web.ServiceUnavailable(w, r, err)
 		return
 	}
 
-	w.Header().Set("Surrogate-Control", web.MaxAge86400)
 	b := []byte(d)
 	web.Ok(w, r, &b)
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/GeoNet/web"
 	"github.com/GeoNet/web/api/apidoc"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -11,84 +16,352 @@ var docs = apidoc.Docs{
 	Production: config.WebServer.Production,
 	APIHost:    config.WebServer.CNAME,
 	Title:      `GeoNet API`,
-	Description: `<p>The data provided here is used for the GeoNet web site and other similar services. 
-			If you are looking for data for research or other purposes then please check the 
-			<a href="http://info.geonet.org.nz/x/DYAO">full range of data</a> available from GeoNet. </p>`,
+	Description: `<p>The data provided here is used for the GeoNet web site and other similar services.
+			If you are looking for data for research or other purposes then please check the
+			<a href="http://info.geonet.org.nz/x/DYAO">full range of data</a> available from GeoNet. </p>
+			<p>There is an <a href="/api-docs/openapi.json">OpenAPI 3 specification</a> for generating clients and contract tests.</p>`,
 	RepoURL:          `https://github.com/GeoNet/geonet-rest`,
 	StrictVersioning: false,
 }
 
+// endpoints are the API docs endpoints.  The docs for the routes, and the version 2 docs for routes that
+// are available as version 2, are added to the endpoint Queries ahead of any docs that are already there.
+var endpoints = []struct {
+	path     string
+	endpoint *apidoc.Endpoint
+}{
+	{"quake", &quakeDoc},
+	{"region", &regionDoc},
+	{"felt", &feltDoc},
+	{"news", &newsDoc},
+	{"impact", &impactDoc},
+	{"volcano", &volcanoDoc},
+	{"fdsn", &fdsnDoc},
+}
+
 func init() {
-	docs.AddEndpoint("quake", &quakeDoc)
-	docs.AddEndpoint("region", &regionDoc)
-	docs.AddEndpoint("felt", &feltDoc)
-	docs.AddEndpoint("news", &newsDoc)
-	docs.AddEndpoint("impact", &impactDoc)
-	docs.AddEndpoint("volcano", &volcanoDoc)
+	for _, e := range endpoints {
+		var q []*apidoc.Query
+
+		for _, rt := range append(getRoutes, postRoutes...) {
+			if rt.endpoint != e.path || rt.doc == nil || containsQuery(q, rt.doc) {
+				continue
+			}
+
+			rt.doc.Accept = rt.accept[0]
+			rt.doc.URI = rt.uri()
+			q = append(q, rt.doc)
+
+			if contains(rt.accept, quakeV2GeoJSON) {
+				q = append(q, v2Doc(rt.doc))
+			}
+		}
+
+		e.endpoint.Queries = append(q, e.endpoint.Queries...)
+		docs.AddEndpoint(e.path, e.endpoint)
+	}
+
+	var err error
+	if openAPIJSON, err = json.Marshal(openAPISpec()); err != nil {
+		log.Fatal(err)
+	}
 }
 
 var exHost = "http://localhost:" + config.WebServer.Port
 
+// all is the media types for queries that return quakes.
+var all = []string{web.V1GeoJSON, web.V1CSV, quakeML12, quakeV2GeoJSON}
+
+// route declares a query.  The route tables drive request dispatch, parameter validation, and the API docs.
+// A request is served by the first route that matches the path and query.
+type route struct {
+	endpoint string           // the API docs endpoint for the query e.g., quake.  Empty if the query is not documented.
+	path     string           // the path.  A (name) segment matches any value for the path parameter name.  A trailing * matches any suffix.
+	query    string           // a query parameter that must be present (name) or have a value (name=value).  Optional.
+	content  []string         // for POST, the request body Content-Types that select the route.  nil matches any Content-Type.
+	accept   []string         // the media types the query is available as in order of preference.  nil if the Accept header is ignored.
+	doc      *apidoc.Query    // the docs for the query.  Also used to check the query parameters.  Optional.
+	fields   bool             // the fields and precision query parameters can be used (see fieldsD).
+	stream   bool             // the response is a long lived stream.  It is not gzipped or cached (see streamHandler).
+	h        http.HandlerFunc // the handler.  Called after the path and query parameters are validated.
+}
+
+var getRoutes = []route{
+	{endpoint: "quake", path: streamPath, accept: []string{eventStream}, doc: quakeStreamD, stream: true, h: quakeStreamHandler},
+	{endpoint: "quake", path: "/quake/changes", accept: []string{web.V1GeoJSON, quakeV2GeoJSON}, doc: quakeChangesD, fields: true, h: quakesChanges},
+	{endpoint: "quake", path: "/quake/history/(publicID)", accept: []string{web.V1GeoJSON, web.V1CSV}, doc: quakeHistoryD, h: quakeHistory},
+	{endpoint: "quake", path: "/quake/(publicID)/sequence", accept: all, doc: quakeSequenceD, fields: true, h: quakeSequence},
+	{endpoint: "quake", path: "/quake/(publicID)/localities", accept: []string{web.V1GeoJSON, web.V1CSV}, doc: quakeLocalitiesD, h: quakeLocalities},
+	{endpoint: "quake", path: "/quake/(publicID)/regions", accept: []string{web.V1GeoJSON, web.V1CSV}, doc: quakeRegionsD, h: quakeRegions},
+	{endpoint: "quake", path: "/quake/(publicID)/intensity", accept: []string{web.V1GeoJSON, web.V1CSV}, doc: quakeIntensityD, h: quakeIntensity},
+	{endpoint: "quake", path: "/quake/(publicID)/contours", accept: []string{web.V1GeoJSON}, doc: quakeContoursD, h: quakeContours},
+	{endpoint: "quake", path: "/quake/(publicID)", accept: all, doc: quakeD, fields: true, h: quake},
+	{endpoint: "quake", path: "/quake", query: "publicID", accept: all, doc: quakesPublicIDD, fields: true, h: quakesPublicID},
+	{endpoint: "quake", path: "/quake", query: "intensity", accept: all, doc: quakesD, fields: true, h: quakes},
+	{endpoint: "quake", path: "/quake", query: "regionIntensity", accept: all, doc: quakesRegionD, fields: true, h: quakesRegion},
+	{endpoint: "quake", path: "/quake", query: "bbox", accept: all, doc: quakesBBoxD, fields: true, h: quakesBBox},
+	{endpoint: "quake", path: "/quake", query: "radius", accept: all, doc: quakesRadiusD, fields: true, h: quakesRadius},
+	{endpoint: "quake", path: "/quake", query: "startTime", accept: all, doc: quakesTimeD, fields: true, h: quakesTime},
+	{endpoint: "impact", path: "/intensity", query: "type=measured", accept: []string{web.V1GeoJSON}, doc: intensityMeasuredLatestD, h: intensityMeasuredLatest},
+	{endpoint: "impact", path: "/intensity/predict", accept: []string{web.V1GeoJSON}, doc: intensityPredictD, h: intensityPredict},
+	{endpoint: "felt", path: "/felt/report", accept: []string{web.V1GeoJSON}, doc: feltD, h: felt},
+	{endpoint: "volcano", path: "/volcano/alert/level", accept: []string{web.V1GeoJSON}, doc: alertLevelD, fields: true, h: alertLevel},
+	{endpoint: "volcano", path: "/volcano/alert/bulletin", accept: []string{web.V1JSON}, doc: alertBulletinD, h: alertBulletin},
+	{endpoint: "region", path: "/region/(regionID)", accept: []string{web.V1GeoJSON}, doc: regionD, fields: true, h: region},
+	{endpoint: "region", path: "/region", query: "type", accept: []string{web.V1GeoJSON}, doc: regionsD, fields: true, h: regions},
+	{endpoint: "news", path: "/news/geonet", accept: []string{web.V1JSON}, doc: newsD, h: news},
+	// FDSN clients select the response format with the format query parameter.  The Accept header must allow it.
+	{endpoint: "fdsn", path: fdsnPath + "query", accept: []string{xmlContent, textContent}, doc: fdsnQueryD, h: fdsnQuery},
+	{endpoint: "fdsn", path: fdsnPath + "version", accept: []string{textContent}, doc: fdsnVersionD, h: fdsnVersionHandler},
+	{endpoint: "fdsn", path: fdsnPath + "application.wadl", accept: []string{xmlContent}, doc: fdsnWADLD, h: fdsnWADLHandler},
+	{path: openAPIPath, accept: []string{"application/json"}, h: openAPIDocs},
+	{path: apidoc.Path + "*", accept: []string{web.HtmlContent}, h: apiDocs},
+	{path: "/soh", accept: []string{web.HtmlContent}, h: soh},
+	{path: "/soh/impact", accept: []string{web.HtmlContent}, h: impactSOH},
+}
+
+// postRoutes are for http POST requests.  The Content-Type of the request body selects the query.
+var postRoutes = []route{
+	{endpoint: "quake", path: "/quake", content: []string{polygonContent, "application/vnd.geo+json"}, accept: all, doc: quakesPolygonD, fields: true, h: quakesPolygon},
+	{endpoint: "quake", path: "/quake", content: []string{publicIDsContent}, accept: all, doc: quakesPublicIDPostD, fields: true, h: quakesPublicIDPost},
+	{path: "/quake", h: badContentType},
+}
+
+// formats convert the GeoJSON from a route handler to the media type for the request.  doc is
+// the docs for the route and may be nil.  Media types that are not in formats are written by the handler.
+var formats = map[string]func(http.ResponseWriter, *http.Request, *apidoc.Query, http.HandlerFunc){
+	web.V1CSV: geoJSONToCSV,
+	quakeML12: geoJSONToQuakeML,
+}
+
+// mediaTypeKey is the request context key for the negotiated media type.
+type mediaTypeKey struct{}
+
+// mediaTypeOf returns the media type that was negotiated for r by dispatch.  Empty if the route
+// ignores the Accept header.
+func mediaTypeOf(r *http.Request) string {
+	mt, _ := r.Context().Value(mediaTypeKey{}).(string)
+	return mt
+}
+
 func router(w http.ResponseWriter, r *http.Request) {
-	// requests that don't have a specific version header are routed to the latest version.
-	var latest bool
-	accept := r.Header.Get("Accept")
-	switch accept {
-	case web.V1GeoJSON, web.V1JSON:
-	default:
-		latest = true
-	}
-
-	switch {
-	case strings.HasPrefix(r.URL.Path, "/quake") && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
-		switch {
-		case r.URL.Query().Get("intensity") != "":
-			quakes(w, r)
-		case r.URL.Query().Get("regionIntensity") != "":
-			quakesRegion(w, r)
-		case strings.HasPrefix(r.URL.Path, "/quake/"):
-			quake(w, r)
-		default:
-			web.BadRequest(w, r, "Can't find a route for this request. Please refer to /api-docs")
+	if !dispatch(w, r, getRoutes) {
+		web.BadRequest(w, r, "Can't find a route for this request. Please refer to /api-docs")
+	}
+}
+
+// streams returns true if the GET request r is for a route that streams its response.
+func streams(r *http.Request) bool {
+	for _, rt := range getRoutes {
+		if _, ok := rt.match(r.URL.Path); ok && rt.selects(r.URL.Query()) {
+			return rt.stream
+		}
+	}
+
+	return false
+}
+
+// postRouter routes http POST requests.
+func postRouter(w http.ResponseWriter, r *http.Request) {
+	if !dispatch(w, r, postRoutes) {
+		web.MethodNotAllowed(w, r)
+	}
+}
+
+// dispatch serves r with the first route in routes that matches the path and query in r.  The media type
+// is negotiated from the Accept header (see negotiate).  Requests that accept any media type e.g., without
+// an Accept header, are served as the first media type for the route so that existing clients are unchanged.
+// Requests for a route that is not available as an acceptable media type are not acceptable.
+// Returns false, without writing to w, if no route matches.
+func dispatch(w http.ResponseWriter, r *http.Request, routes []route) bool {
+	for _, rt := range routes {
+		p, ok := rt.match(r.URL.Path)
+		if !ok || !rt.selects(r.URL.Query()) || !rt.contains(r.Header.Get("Content-Type")) {
+			continue
+		}
+
+		if rt.accept == nil {
+			removeVary(w.Header(), "Accept")
+			rt.checked(p)(w, r)
+			return true
+		}
+
+		mt, ok := negotiate(strings.Join(r.Header["Accept"], ","), rt.accept)
+		if !ok {
+			web.NotAcceptable(w, r, "this query is only available as: "+strings.Join(rt.accept, ", "))
+			return true
 		}
-	case r.URL.Path == "/intensity" && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
+
+		w.Header().Set("Content-Type", mt)
+		r = r.WithContext(context.WithValue(r.Context(), mediaTypeKey{}, mt))
+
+		if f, ok := formats[mt]; ok {
+			f(w, r, rt.doc, rt.checked(p))
+		} else {
+			rt.checked(p)(w, r)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// removeVary removes v from the Vary header in h.  For responses that don't depend on
+// a request header that Vary is set for by default.
+func removeVary(h http.Header, v string) {
+	var vary []string
+
+	for _, s := range h["Vary"] {
+		if !strings.EqualFold(s, v) {
+			vary = append(vary, s)
+		}
+	}
+
+	if vary == nil {
+		h.Del("Vary")
+		return
+	}
+
+	h["Vary"] = vary
+}
+
+// match returns the path parameters and true if p matches the route path.
+func (rt route) match(p string) (map[string]string, bool) {
+	if strings.HasSuffix(rt.path, "*") {
+		return nil, strings.HasPrefix(p, strings.TrimSuffix(rt.path, "*"))
+	}
+
+	want := strings.Split(rt.path, "/")
+	got := strings.Split(p, "/")
+
+	if len(want) != len(got) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for i, s := range want {
 		switch {
-		case r.URL.Query().Get("type") == "measured":
-			intensityMeasuredLatest(w, r)
-		// case r.URL.Query().Get("type") == "reported" && r.URL.Query().Get("publicID") == "":
-		// 	intensityReportedLatest(w, r)
-		// case r.URL.Query().Get("type") == "reported" && r.URL.Query().Get("publicID") != "":
-		// 	intensityReported(w, r)
-		default:
-			web.BadRequest(w, r, "Can't find a route for this request. Please refer to /api-docs")
+		case strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")"):
+			params[s[1:len(s)-1]] = got[i]
+		case s != got[i]:
+			return nil, false
 		}
-	case r.URL.Path == "/felt/report" && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
-		felt(w, r)
-	case r.URL.Path == "/volcano/alert/level" && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
-		alertLevel(w, r)
-	case r.URL.Path == "/volcano/alert/bulletin" && (accept == web.V1JSON || latest):
-		w.Header().Set("Content-Type", web.V1JSON)
-		alertBulletin(w, r)
-	case strings.HasPrefix(r.URL.Path, "/region/") && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
-		region(w, r)
-	case r.URL.Path == "/region" && (accept == web.V1GeoJSON || latest):
-		w.Header().Set("Content-Type", web.V1GeoJSON)
-		regions(w, r)
-	case r.URL.Path == "/news/geonet" && (accept == web.V1JSON || latest):
-		w.Header().Set("Content-Type", web.V1JSON)
-		news(w, r)
-	case strings.HasPrefix(r.URL.Path, apidoc.Path):
-		docs.Serve(w, r)
-	case r.URL.Path == "/soh":
-		soh(w, r)
-	case r.URL.Path == "/soh/impact":
-		impactSOH(w, r)
-	default:
-		web.BadRequest(w, r, "Can't find a route for this request. Please refer to /api-docs")
 	}
+
+	return params, true
+}
+
+// selects returns true if the route query matches v.
+func (rt route) selects(v url.Values) bool {
+	if rt.query == "" {
+		return true
+	}
+
+	if i := strings.Index(rt.query, "="); i > 0 {
+		return v.Get(rt.query[:i]) == rt.query[i+1:]
+	}
+
+	return v.Get(rt.query) != ""
+}
+
+// contains returns true if the request body Content-Type c selects the route.
+func (rt route) contains(c string) bool {
+	if rt.content == nil {
+		return true
+	}
+
+	t := parseMediaType(c)
+
+	return contains(rt.content, t.typ+"/"+t.subtype)
+}
+
+// checked returns the route handler wrapped so that the path parameters p and the query parameters are
+// validated first.  Query parameters are only checked for routes with docs.
+func (rt route) checked(p map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for k, s := range p {
+			if err := pathParams.check(k, s); err != nil {
+				web.BadRequest(w, r, err.Error())
+				return
+			}
+		}
+
+		if rt.doc != nil {
+			v := r.URL.Query()
+
+			if rt.fields {
+				if err := checkFields(rt.doc, v); err != nil {
+					web.BadRequest(w, r, err.Error())
+					return
+				}
+				v = withoutFields(v)
+			}
+
+			if err := rt.doc.CheckParams(v); err != nil {
+				web.BadRequest(w, r, err.Error())
+				return
+			}
+
+			for k := range r.URL.Query() {
+				if err := queryParams.check(k, r.URL.Query().Get(k)); err != nil {
+					web.BadRequest(w, r, err.Error())
+					return
+				}
+			}
+		}
+
+		rt.h(w, r)
+	}
+}
+
+// uri returns the URI for the route docs; the path followed by the selecting query parameter
+// and then the other required query parameters in alphabetical order.  Optional query parameters
+// are only in the docs.
+func (rt route) uri() string {
+	var q []string
+	var s string
+
+	switch i := strings.Index(rt.query, "="); {
+	case i > 0:
+		s = rt.query[:i]
+		q = append(q, rt.query)
+	case rt.query != "":
+		s = rt.query
+		q = append(q, s+"=("+s+")")
+	}
+
+	var req []string
+	for k := range rt.doc.Required {
+		if k != s {
+			req = append(req, k)
+		}
+	}
+	sort.Strings(req)
+
+	for _, k := range req {
+		q = append(q, k+"=("+k+")")
+	}
+
+	if len(q) == 0 {
+		return rt.path
+	}
+
+	return rt.path + "?" + strings.Join(q, "&")
+}
+
+func apiDocs(w http.ResponseWriter, r *http.Request) {
+	docs.Serve(w, r)
+}
+
+// containsQuery returns true if q is in s.
+func containsQuery(s []*apidoc.Query, q *apidoc.Query) bool {
+	for _, v := range s {
+		if v == q {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"github.com/GeoNet/web"
 	"github.com/GeoNet/web/webtest"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -22,8 +28,24 @@ func TestRoutes(t *testing.T) {
 		TestAccept: false,
 	}
 	r.Add("/quake/2013p407387")
+	r.Add("/quake/history/2011a440804")
+	r.Add("/quake/history/2013p407387")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z&limit=2")
+	r.Add("/quake/2013p407387/sequence")
+	r.Add("/quake/2013p407387/localities")
+	r.Add("/quake/2013p407387/regions")
+	r.Add("/quake?publicID=2013p407387,2013p407399")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&fields=publicID,time&precision=3")
+	r.Add("/volcano/alert/level?fields=volcanoID,level")
+	r.Add("/quake/2013p407387/contours")
+	r.Add("/quake/2013p407387/intensity?lat=-43.53&lon=172.63")
 	r.Add("/felt/report?publicID=2013p407387")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good&limit=1")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good&limit=1&cursor=MjAxMy0wNS0zMFQxMjowMDowMFogMjAxM3A0MDczODc")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=1&cursor=MjAxMy0wNS0zMFQxMjowMDowMFogMjAxM3A0MDczODc")
 	r.Add("/quake?regionID=newzealand&regionIntensity=weak&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&regionIntensity=light&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&regionIntensity=moderate&number=30&quality=best,caution,good")
@@ -35,6 +57,9 @@ func TestRoutes(t *testing.T) {
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=500&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=1000&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=1500&quality=best,caution,good")
+	// number is the same as limit so any limit and no number are no longer bad requests.
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=999&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good")
 	r.Add("/quake?regionID=aucklandnorthland&regionIntensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=tongagrirobayofplenty&regionIntensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=gisborne&regionIntensity=unnoticeable&number=3&quality=best,caution,good")
@@ -57,6 +82,9 @@ func TestRoutes(t *testing.T) {
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=500&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=1000&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=1500&quality=best,caution,good")
+	// number is the same as limit so any limit and no number are no longer bad requests.
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=999&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good")
 	r.Add("/quake?regionID=aucklandnorthland&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=tongagrirobayofplenty&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=gisborne&intensity=unnoticeable&number=3&quality=best,caution,good")
@@ -67,7 +95,22 @@ func TestRoutes(t *testing.T) {
 	r.Add("/quake?regionID=canterbury&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=fiordland&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=otagosouthland&intensity=unnoticeable&number=3&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=1")
+	r.Add("/quake?startTime=2013-05-30&endTime=2013-05-31&limit=10000")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0")
+	r.Add("/quake?bbox=179,-38,-179,-37&limit=10")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0&startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=50")
+	r.Add("/quake?lat=-37.9&lon=180&radius=50&limit=10")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=50&startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good&minMag=3&maxMag=5")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good&minDepth=40&maxDepth=600&type=earthquake")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good&minMag=3&type=earthquake,landslide")
+	r.Add("/quake?startTime=2012-02-04&endTime=2012-02-05&minMag=4.1&maxDepth=30")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0&type=earthquake")
 	r.Add("/intensity?type=measured")
+	r.Add("/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7")
 	// r.Add("/intensity?type=reported&zoom=5")
 	// r.Add("/intensity?type=reported&zoom=5&publicID=2012p673624")
 	r.Add("/volcano/alert/level")
@@ -131,6 +174,7 @@ func TestRoutes(t *testing.T) {
 	r.Add("/quake?regionID=fiordland&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=otagosouthland&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/intensity?type=measured")
+	r.Add("/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=7")
 	// r.Add("/intensity?type=reported&zoom=5")
 	// r.Add("/intensity?type=reported&zoom=5&publicID=2012p673624")
 	r.Add("/volcano/alert/level")
@@ -158,6 +202,7 @@ func TestRoutes(t *testing.T) {
 	r.Add("/region/canterbury")
 	r.Add("/region/fiordland")
 	r.Add("/region/otagosouthland")
+	r.Add("/region/canterbury?fields=regionID,title&precision=2")
 
 	r.Test(ts, t)
 
@@ -172,10 +217,253 @@ func TestRoutes(t *testing.T) {
 		TestAccept: false,
 	}
 	r.Add("/quake/2013p407399")
+	r.Add("/quake/history/2013p407399")
+	r.Add("/quake/2013p407399/sequence")
+	r.Add("/quake/2013p407399/localities")
+	r.Add("/quake/2013p407399/regions")
+	r.Add("/quake/2013p407399/contours")
+	r.Add("/quake/2013p407399/intensity?lat=-43.53&lon=172.63")
 	r.Add("/felt/report?publicID=2013p407399")
 
 	r.Test(ts, t)
 
+	// CSV routes
+	r = webtest.Route{
+		Accept:     web.V1CSV,
+		Content:    web.V1CSV,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake/history/2011a440804")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=50")
+
+	r.Test(ts, t)
+
+	// QuakeML routes
+	r = webtest.Route{
+		Accept:     quakeML12,
+		Content:    quakeML12,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=50")
+
+	r.Test(ts, t)
+
+	// CSV routes that should 404
+	r = webtest.Route{
+		Accept:     web.V1CSV,
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusNotFound,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407399")
+
+	r.Test(ts, t)
+
+	// Quake changes and intensity contours are only available as GeoJSON
+	r = webtest.Route{
+		Accept:     web.V1CSV,
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z")
+	r.Add("/quake/2013p407387/contours")
+
+	r.Test(ts, t)
+
+	// Version 2 quake GeoJSON routes
+	r = webtest.Route{
+		Accept:     quakeV2GeoJSON,
+		Content:    quakeV2GeoJSON,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake/2013p407387/sequence")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z")
+	r.Add("/quake?publicID=2013p407387,2013p407399")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=50")
+
+	r.Test(ts, t)
+
+	// Version 2 is only available for queries that return quakes
+	r = webtest.Route{
+		Accept:     quakeV2GeoJSON,
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/history/2013p407387")
+	r.Add("/quake/2013p407387/localities")
+	r.Add("/quake/2013p407387/contours")
+	r.Add("/region/newzealand")
+	r.Add("/volcano/alert/level")
+
+	r.Test(ts, t)
+
+	// Versions that are not served are not acceptable
+	r = webtest.Route{
+		Accept:     "application/vnd.geo+json;version=3",
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/region/newzealand")
+	r.Add("/api-docs")
+
+	r.Test(ts, t)
+
+	// Accept headers with several media ranges are negotiated using the q-values
+	r = webtest.Route{
+		Accept:     "application/vnd.geo+json;version=1, */*;q=0.1",
+		Content:    web.V1GeoJSON,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+	r.Add("/quake/2013p407387/localities")
+	r.Add("/felt/report?publicID=2013p407387")
+	r.Add("/volcano/alert/level")
+
+	r.Test(ts, t)
+
+	r = webtest.Route{
+		Accept:     "application/vnd.geo+json;q=0.5, text/csv",
+		Content:    web.V1CSV,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake/history/2011a440804")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
+
+	r.Test(ts, t)
+
+	r = webtest.Route{
+		Accept:     "application/vnd.geo+json; version=2, application/vnd.geo+json; version=1; q=0.5",
+		Content:    quakeV2GeoJSON,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z")
+
+	r.Test(ts, t)
+
+	// Falls back to version 1 for queries that are not available as version 2
+	r = webtest.Route{
+		Accept:     "application/vnd.geo+json; version=2, application/vnd.geo+json; version=1; q=0.5",
+		Content:    web.V1GeoJSON,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387/localities")
+	r.Add("/volcano/alert/level")
+
+	r.Test(ts, t)
+
+	// Media ranges that don't match any of the media types for the query, or have q=0, are not acceptable
+	r = webtest.Route{
+		Accept:     "text/csv, application/xml, application/vnd.geo+json;q=0",
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/2013p407387/contours")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z")
+	r.Add("/volcano/alert/level")
+	r.Add("/news/geonet")
+	r.Add("/api-docs")
+
+	r.Test(ts, t)
+
+	// The quake stream, FDSN, and soh routes are negotiated the same as the other routes
+	r = webtest.Route{
+		Accept:     "application/json",
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/stream")
+	r.Add("/fdsnws/event/1/query?eventid=2013p407387")
+	r.Add("/fdsnws/event/1/version")
+	r.Add("/fdsnws/event/1/application.wadl")
+	r.Add("/soh")
+	r.Add("/soh/impact")
+
+	r.Test(ts, t)
+
+	// The FDSN format must be acceptable
+	r = webtest.Route{
+		Accept:     xmlContent,
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusNotAcceptable,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/fdsnws/event/1/query?eventid=2013p407387&format=text")
+
+	r.Test(ts, t)
+
 	// JSON routes
 	r = webtest.Route{
 		Accept:     web.V1JSON,
@@ -191,6 +479,83 @@ func TestRoutes(t *testing.T) {
 
 	r.Test(ts, t)
 
+	r = webtest.Route{
+		Accept:     "application/json",
+		Content:    web.V1JSON,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge300,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/news/geonet")
+	r.Add("/volcano/alert/bulletin")
+
+	r.Test(ts, t)
+
+	// OpenAPI specification
+	r = webtest.Route{
+		Accept:     "application/json",
+		Content:    "application/json",
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge300,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/api-docs/openapi.json")
+
+	r.Test(ts, t)
+
+	// FDSN event text routes
+	r = webtest.Route{
+		Accept:     "",
+		Content:    textContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/fdsnws/event/1/version")
+	r.Add("/fdsnws/event/1/query?eventid=2013p407387&format=text")
+	r.Add("/fdsnws/event/1/query?starttime=2013-05-30T00:00:00&endtime=2013-05-31T00:00:00&format=text")
+	r.Add("/fdsnws/event/1/query?start=2013-05-30&end=2013-05-31&minmag=3&format=text")
+	r.Add("/fdsnws/event/1/query?minlat=-44&maxlat=-43&minlon=172&maxlon=173&format=text")
+	r.Add("/fdsnws/event/1/query?lat=-43.4&lon=172.3&maxradius=1&format=text")
+
+	r.Test(ts, t)
+
+	// FDSN event XML routes
+	r = webtest.Route{
+		Accept:     "",
+		Content:    xmlContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusOK,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/fdsnws/event/1/application.wadl")
+	r.Add("/fdsnws/event/1/query?eventid=2013p407387")
+	r.Add("/fdsnws/event/1/query?starttime=2013-05-30T00:00:00&endtime=2013-05-31T00:00:00&orderby=magnitude&format=xml")
+
+	r.Test(ts, t)
+
+	// FDSN event routes that should 404
+	r = webtest.Route{
+		Accept:     "",
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge10,
+		Response:   http.StatusNotFound,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/fdsnws/event/1/query?eventid=2013p407399&nodata=404")
+
+	r.Test(ts, t)
+
 	// GeoJSON routes that should bad request
 	r = webtest.Route{
 		Accept:     web.V1GeoJSON,
@@ -203,8 +568,11 @@ func TestRoutes(t *testing.T) {
 	}
 	r.Add("/quake?regionID=newzealand&regionIntensity=bad&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,bad")
-	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=999&quality=best,caution,good")
-	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=10001&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=0&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=bad&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&limit=30&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&quality=best,caution,good&cursor=bad")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable")
 	r.Add("/quake?regionID=newzealand")
 	r.Add("/quake")
@@ -212,17 +580,109 @@ func TestRoutes(t *testing.T) {
 	r.Add("/quake?regionID=bad&regionIntensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&intensity=bad&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,bad")
-	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=999&quality=best,caution,good")
-	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=10001&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=0&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=bad&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&limit=30&quality=best,caution,good")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&quality=best,caution,good&cursor=bad")
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable")
 	r.Add("/quake?regionID=newzealand")
 	r.Add("/quake")
 	r.Add("/quake?regionID=ruapehu&intensity=unnoticeable&number=3&quality=best,caution,good")
 	r.Add("/quake?regionID=bad&intensity=unnoticeable&number=3&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-31T00:00:00Z&endTime=2013-05-30T00:00:00Z")
+	r.Add("/quake?startTime=bad&endTime=2013-05-30T00:00:00Z")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=bad")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=0")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=10001")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&limit=bad")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z&cursor=bad")
+	r.Add("/quake?bbox=172.0,-44.0,173.0")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,bad")
+	r.Add("/quake?bbox=172.0,-43.0,173.0,-44.0")
+	r.Add("/quake?bbox=172.0,-44.0,181.0,-43.0")
+	r.Add("/quake?bbox=172.0,-91.0,173.0,-43.0")
+	r.Add("/quake?bbox=172.0,-44.0,173.0,-43.0&limit=0")
+	r.Add("/quake?lat=-43.4&lon=172.28")
+	r.Add("/quake?lat=-43.4&radius=50")
+	r.Add("/quake?lat=-91&lon=172.28&radius=50")
+	r.Add("/quake?lat=-43.4&lon=361&radius=50")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=0")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=1001")
+	r.Add("/quake?lat=-43.4&lon=172.28&radius=bad")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good&minMag=bad")
+	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good&minMag=5&maxMag=3")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good&maxDepth=bad")
+	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good&type=bad'type")
+	r.Add("/quake?startTime=2012-02-04&endTime=2012-02-05&minDepth=100&maxDepth=10")
 	r.Add("/region/bad")
 	r.Add("/region?type=badQuery")
 	r.Add("/")
 	r.Add("/felt/report?quakeID=2012p498491")
+	r.Add("/quake/changes")
+	r.Add("/quake/2013p407387/sequence?limit=10")
+	r.Add("/quake/2013P407387/sequence")
+	r.Add("/quake/2013P407387")
+	r.Add("/quake/history/2013P407387")
+	r.Add("/quake/2013p407387/localities?size=0")
+	r.Add("/quake/2013p407387/regions?regionID=canterbury")
+	r.Add("/quake?publicID=2013P407387")
+	r.Add("/quake?publicID=2013p407387,,2013p407399")
+	r.Add("/quake?publicID=2013p407387&limit=10")
+	r.Add("/quake/2013p407387?precision=bad")
+	r.Add("/quake/2013p407387?fields=")
+	r.Add("/quake/2013p407387?fields=publicID&limit=1")
+	r.Add("/quake/2013p407387/contours?mmi=3")
+	r.Add("/quake/2013p407387/intensity?lat=bad&lon=172.63")
+	r.Add("/quake/2013p407387/intensity?lat=-43.53")
+	r.Add("/quake/2013p407387/intensity?lat=-43.53&lon=400")
+	r.Add("/quake/2013p407387/intensity?lat=-43.53&lon=172.63&radius=10")
+	r.Add("/intensity/predict?lat=-43.53&lon=172.63&depth=10")
+	r.Add("/intensity/predict?lat=-43.53&lon=172.63&depth=-1&magnitude=7")
+	r.Add("/intensity/predict?lat=-43.53&lon=172.63&depth=10&magnitude=11")
+	r.Add("/intensity/predict?lat=-93.53&lon=172.63&depth=10&magnitude=7")
+	r.Add("/quake/changes?since=bad")
+	r.Add("/quake/changes?since=2012-01-01T00:00:00Z&limit=0")
+	r.Test(ts, t)
+
+	// Quake stream routes that should bad request
+	r = webtest.Route{
+		Accept:     eventStream,
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusBadRequest,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/quake/stream?intensity=bad")
+	r.Add("/quake/stream?regionID=bad")
+	r.Add("/quake/stream?number=3")
+	r.Test(ts, t)
+
+	// FDSN event routes that should bad request.  FDSN clients usually don't send an Accept header.
+	r = webtest.Route{
+		Accept:     "",
+		Content:    web.ErrContent,
+		Cache:      web.MaxAge10,
+		Surrogate:  web.MaxAge86400,
+		Response:   http.StatusBadRequest,
+		Vary:       "Accept",
+		TestAccept: false,
+	}
+	r.Add("/fdsnws/event/1/bad")
+	r.Add("/fdsnws/event/1/query?starttime=bad")
+	r.Add("/fdsnws/event/1/query?start=2013-05-30&starttime=2013-05-30")
+	r.Add("/fdsnws/event/1/query?minlatitude=bad")
+	r.Add("/fdsnws/event/1/query?minlatitude=-43&maxlatitude=-44")
+	r.Add("/fdsnws/event/1/query?latitude=-43.4&longitude=172.3&minradius=2&maxradius=1")
+	r.Add("/fdsnws/event/1/query?orderby=bad")
+	r.Add("/fdsnws/event/1/query?format=bad")
+	r.Add("/fdsnws/event/1/query?nodata=bad")
+	r.Add("/fdsnws/event/1/query?limit=0")
+	r.Add("/fdsnws/event/1/query?offset=0")
+	r.Add("/fdsnws/event/1/query?includeallorigins=true")
 	r.Test(ts, t)
 
 }
@@ -242,10 +702,12 @@ func TestGeoJSON(t *testing.T) {
 		TestAccept: false,
 	}
 	r.Add("/quake/2013p407387")
+	r.Add("/quake/history/2011a440804")
 	r.Add("/quake?regionID=newzealand&regionIntensity=unnoticeable&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=wellington&regionIntensity=severe&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=newzealand&intensity=unnoticeable&number=30&quality=best,caution,good")
 	r.Add("/quake?regionID=wellington&intensity=severe&number=30&quality=best,caution,good")
+	r.Add("/quake?startTime=2013-05-30T00:00:00Z&endTime=2013-05-31T00:00:00Z")
 	r.Add("/region/tongagrirobayofplenty")
 	r.Add("/region?type=quake")
 	r.Add("/felt/report?publicID=2013p407387")
@@ -256,3 +718,387 @@ func TestGeoJSON(t *testing.T) {
 
 	r.GeoJSON(ts, t)
 }
+
+// TestRouteDocs checks that the docs for each route describe the route path parameters.
+func TestRouteDocs(t *testing.T) {
+	for _, rt := range append(getRoutes, postRoutes...) {
+		if rt.doc == nil {
+			continue
+		}
+
+		if rt.endpoint == "" {
+			t.Errorf("%s has docs but no endpoint", rt.path)
+		}
+
+		p, ok := rt.match(strings.Replace(strings.Replace(rt.path, "(", "", -1), ")", "", -1))
+		if !ok {
+			t.Errorf("%s doesn't match its own path", rt.path)
+		}
+
+		for k := range p {
+			if _, ok := rt.doc.Params[k]; !ok {
+				t.Errorf("%s: no docs for path parameter %s", rt.path, k)
+			}
+
+			if _, ok := rt.doc.Required[k]; ok {
+				t.Errorf("%s: path parameter %s is documented as a query parameter", rt.path, k)
+			}
+		}
+
+		if !strings.HasPrefix(rt.uri(), rt.path) {
+			t.Errorf("%s: incorrect URI %s", rt.path, rt.uri())
+		}
+
+		if !contains(rt.accept, quakeV2GeoJSON) {
+			continue
+		}
+
+		var v2 bool
+		for _, q := range quakeDoc.Queries {
+			v2 = v2 || (q.Accept == quakeV2GeoJSON && q.URI == rt.uri() && q.Title == rt.doc.Title+" - Version 2")
+		}
+		if !v2 {
+			t.Errorf("%s: no version 2 docs", rt.uri())
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	in := []struct {
+		uri    string
+		stream bool
+	}{
+		{"/quake/stream", true},
+		{"/quake/stream?regionID=newzealand", true},
+		{"/quake/2013p407387", false},
+		{"/quake/changes?since=2012-01-01T00:00:00Z", false},
+		{"/fdsnws/event/1/query", false},
+	}
+
+	for _, v := range in {
+		r, err := http.NewRequest("GET", v.uri, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if streams(r) != v.stream {
+			t.Errorf("%s: expected stream %t", v.uri, v.stream)
+		}
+	}
+}
+
+func TestRouteMatch(t *testing.T) {
+	in := []struct {
+		path  string
+		query string
+		p     string
+		q     string
+		ok    bool
+		id    string
+	}{
+		{"/quake/(publicID)", "", "/quake/2013p407387", "", true, "2013p407387"},
+		{"/quake/(publicID)", "", "/quake/2013p407387/sequence", "", false, ""},
+		{"/quake/(publicID)/sequence", "", "/quake/2013p407387/sequence", "", true, "2013p407387"},
+		{"/quake/changes", "", "/quake/changes", "", true, ""},
+		{"/quake", "publicID", "/quake", "publicID=2013p407387", true, ""},
+		{"/quake", "publicID", "/quake", "startTime=2013-05-30", false, ""},
+		{"/intensity", "type=measured", "/intensity", "type=measured", true, ""},
+		{"/intensity", "type=measured", "/intensity", "type=reported", false, ""},
+		{"/fdsnws/event/1/query", "", "/fdsnws/event/1/query", "", true, ""},
+		{"/api-docs*", "", "/api-docs/openapi.json", "", true, ""},
+	}
+
+	for _, v := range in {
+		rt := route{path: v.path, query: v.query}
+
+		q, err := url.ParseQuery(v.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, ok := rt.match(v.p)
+		ok = ok && rt.selects(q)
+
+		if ok != v.ok {
+			t.Errorf("%s?%s: expected match %t for %s?%s", v.path, v.query, v.ok, v.p, v.q)
+		}
+
+		if ok && p["publicID"] != v.id {
+			t.Errorf("%s: expected publicID %s got %s", v.p, v.id, p["publicID"])
+		}
+	}
+}
+
+func TestParamsCheck(t *testing.T) {
+	in := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"intensity", "weak", true},
+		{"intensity", "weak,light", false},
+		{"intensity", "bad", false},
+		{"quality", "best,caution,good", true},
+		{"quality", "best,caution,bad", false},
+		{"type", "earthquake,landslide", true},
+		{"type", "bad'type", false},
+		{"regionID", "anything", true},
+	}
+
+	for _, v := range in {
+		if err := queryParams.check(v.name, v.value); (err == nil) != v.ok {
+			t.Errorf("%s=%s: expected valid %t got %v", v.name, v.value, v.ok, err)
+		}
+	}
+
+	if err := pathParams.check("publicID", "2013P407387"); err == nil {
+		t.Error("expected an error for an invalid publicID")
+	}
+}
+
+// TestPostRoutes checks that the Content-Type selects the POST /quake query and that the query parameters
+// are checked against the docs for the query before the request body is read.
+func TestPostRoutes(t *testing.T) {
+	in := []struct {
+		content string
+		query   string
+	}{
+		{"text/plain", ""},
+		{"", ""},
+		{polygonContent, "?publicID=2013p407387"},
+		{"application/vnd.geo+json", "?startTime=bad"},
+		{polygonContent, "?fields=notAProperty"},
+		{publicIDsContent + ";charset=utf-8", "?limit=10"},
+		{publicIDsContent, "?fields=notAProperty"},
+	}
+
+	for _, v := range in {
+		r := httptest.NewRequest("POST", "/quake"+v.query, strings.NewReader("{}"))
+		r.Header.Set("Content-Type", v.content)
+		w := httptest.NewRecorder()
+
+		postRouter(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST /quake%s with %s: expected status %d got %d", v.query, v.content, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	for _, v := range []string{polygonContent, "application/vnd.geo+json", publicIDsContent} {
+		var n int
+		for _, rt := range postRoutes {
+			if rt.doc != nil && rt.contains(v) {
+				n++
+			}
+		}
+
+		if n != 1 {
+			t.Errorf("%s: expected 1 documented POST route got %d", v, n)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	in := []struct {
+		accept    string
+		available []string
+		mt        string
+		ok        bool
+	}{
+		{"", all, web.V1GeoJSON, true},
+		{"*/*", all, web.V1GeoJSON, true},
+		{browser, all, web.V1GeoJSON, true},
+		{browser, []string{web.V1GeoJSON, web.HtmlContent}, web.HtmlContent, true},
+		{web.V1CSV, all, web.V1CSV, true},
+		{quakeV2GeoJSON, all, quakeV2GeoJSON, true},
+		{"application/vnd.geo+json", all, web.V1GeoJSON, true},
+		{"application/vnd.geo+json;version=1, */*;q=0.1", all, web.V1GeoJSON, true},
+		{"application/vnd.geo+json;version=2;q=0.5, text/csv", all, web.V1CSV, true},
+		{"Application/Vnd.Geo+JSON; Version=2", all, quakeV2GeoJSON, true},
+		{`application/vnd.geo+json;version="2"`, all, quakeV2GeoJSON, true},
+		{"text/*", all, web.V1CSV, true},
+		{"text/*;q=0.5, text/csv;q=0", all, "", false},
+		{"*/*, application/vnd.geo+json;q=0", all, web.V1CSV, true},
+		{"application/vnd.geo+json;version=3", all, "", false},
+		{"application/vnd.geo+json;version=2", []string{web.V1GeoJSON}, "", false},
+		{"application/json", []string{web.V1JSON}, web.V1JSON, true},
+		{"application/json", []string{web.V1GeoJSON}, "", false},
+		{"text/csv;q=bad", all, "", false},
+		{"bad", all, "", false},
+	}
+
+	for _, v := range in {
+		mt, ok := negotiate(v.accept, v.available)
+		if mt != v.mt || ok != v.ok {
+			t.Errorf("%s: expected %s %t got %s %t", v.accept, v.mt, v.ok, mt, ok)
+		}
+	}
+}
+
+// TestOpenAPI checks that there is an operation in the OpenAPI specification for each documented route.
+func TestOpenAPI(t *testing.T) {
+	var s openAPI
+
+	if err := json.Unmarshal(openAPIJSON, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.OpenAPI != "3.0.3" {
+		t.Errorf("incorrect OpenAPI version %s", s.OpenAPI)
+	}
+
+	ids := make(map[string]bool)
+
+	for _, m := range s.Paths {
+		for _, o := range m {
+			if ids[o.OperationID] {
+				t.Errorf("duplicate operationId %s", o.OperationID)
+			}
+			ids[o.OperationID] = true
+		}
+	}
+
+	for _, rt := range getRoutes {
+		if rt.doc == nil {
+			continue
+		}
+
+		p := openAPIPathOf(rt.path)
+
+		o, ok := s.Paths[p]["get"]
+		if !ok {
+			t.Errorf("no operation for %s", p)
+			continue
+		}
+
+		for _, mt := range rt.accept {
+			if _, ok := o.Responses["200"].Content[mt]; !ok {
+				t.Errorf("%s: no response content for %s", p, mt)
+			}
+		}
+
+		for k := range rt.doc.Params {
+			var found bool
+			for _, v := range o.Parameters {
+				found = found || (v.Name == k && v.In == "path" && v.Required)
+			}
+			if !found {
+				t.Errorf("%s: no required path parameter %s", p, k)
+			}
+		}
+	}
+
+	if o, ok := s.Paths["/quake"]["post"]; !ok {
+		t.Error("no operation for POST /quake")
+	} else if o.RequestBody == nil || len(o.RequestBody.Content) != 2 {
+		t.Error("POST /quake: expected request bodies for a polygon and a list of quake IDs")
+	}
+
+	// the selecting query parameters for the /quake queries are not required for the merged operation.
+	for _, v := range s.Paths["/quake"]["get"].Parameters {
+		if v.Required {
+			t.Errorf("/quake: unexpected required parameter %s", v.Name)
+		}
+
+		if v.Name == "intensity" && len(v.Schema.Enum) != 6 {
+			t.Errorf("/quake: expected enum for intensity got %v", v.Schema.Enum)
+		}
+
+		if v.Name == "quality" && (v.Schema.Type != "array" || v.Schema.Items == nil || len(v.Schema.Items.Enum) != 4) {
+			t.Errorf("/quake: expected an array of enum for quality")
+		}
+	}
+
+	for _, v := range s.Paths["/intensity"]["get"].Parameters {
+		if v.Name == "type" && (!v.Required || len(v.Schema.Enum) != 1 || v.Schema.Enum[0] != "measured") {
+			t.Errorf("/intensity: expected required type=measured")
+		}
+	}
+
+	fc := s.Paths["/quake/{publicID}/sequence"]["get"].Responses["200"].Content[quakeV2GeoJSON].Schema
+	if fc == nil || fc.Properties["summary"] == nil || fc.Properties["bbox"] == nil {
+		t.Error("/quake/{publicID}/sequence: expected summary and bbox FeatureCollection members for version 2")
+	}
+
+	// the properties that are only in version 2 are documented with the quake properties.
+	for mt, e := range map[string]bool{web.V1GeoJSON: false, quakeV2GeoJSON: true} {
+		fc := s.Paths["/quake/{publicID}"]["get"].Responses["200"].Content[mt].Schema
+		p := fc.Properties["features"].Items.Properties["properties"].Properties
+
+		if _, ok := p["magnitudeType"]; ok != e {
+			t.Errorf("/quake/{publicID}: %s expected magnitudeType property %t", mt, e)
+		}
+
+		c := fc.Properties["features"].Items.Properties["geometry"].Properties["coordinates"]
+		if n := map[bool]int{false: 2, true: 3}[e]; c.MinItems != n || c.MaxItems != n {
+			t.Errorf("/quake/{publicID}: %s expected %d coordinates", mt, n)
+		}
+	}
+
+	// property types.
+	fc = s.Paths["/quake"]["get"].Responses["200"].Content[quakeV2GeoJSON].Schema
+	p := fc.Properties["features"].Items.Properties["properties"].Properties
+
+	for k, v := range map[string]string{"time": "string", "modificationTime": "string", "depth": "number", "magnitude": "number",
+		"usedPhaseCount": "integer", "magnitudeStationCount": "integer", "publicID": "string"} {
+		if p[k] == nil || p[k].Type != v {
+			t.Errorf("/quake: expected %s property %s", v, k)
+		}
+	}
+
+	if p["time"].Format != "date-time" || p["modificationTime"].Format != "date-time" {
+		t.Error("/quake: expected date-time properties")
+	}
+
+	if !reflect.DeepEqual(p["intensity"].Enum, queryParams["intensity"].values) {
+		t.Errorf("/quake: expected intensity enum got %v", p["intensity"].Enum)
+	}
+
+	if !contains(p["quality"].Enum, "unknown") || !contains(p["quality"].Enum, "best") {
+		t.Errorf("/quake: expected quality enum got %v", p["quality"].Enum)
+	}
+
+	if fc.Properties["next"] == nil || fc.Properties["next"].Type != "string" || !fc.Properties["next"].Nullable {
+		t.Error("/quake: expected a nullable string next cursor")
+	}
+
+	fc = s.Paths["/quake/changes"]["get"].Responses["200"].Content[web.V1GeoJSON].Schema
+	if fc.Properties["next"] == nil || fc.Properties["next"].Format != "date-time" {
+		t.Error("/quake/changes: expected a date-time next")
+	}
+
+	if fc.Properties["deleted"] == nil || fc.Properties["deleted"].Type != "array" {
+		t.Error("/quake/changes: expected a deleted array FeatureCollection member")
+	}
+
+	fc = s.Paths["/region/{regionID}"]["get"].Responses["200"].Content[web.V1GeoJSON].Schema
+	if g := fc.Properties["features"].Items.Properties["geometry"]; len(g.Properties["type"].Enum) != 1 || g.Properties["type"].Enum[0] != "Polygon" {
+		t.Error("/region/{regionID}: expected Polygon geometry")
+	}
+
+	// the required query parameters for each of the /quake queries are in x-queries.
+	for m, routes := range map[string][]route{"get": getRoutes, "post": postRoutes} {
+		for _, rt := range routes {
+			if rt.doc == nil || rt.path != "/quake" {
+				continue
+			}
+
+			var req []string
+			for k := range rt.doc.Required {
+				req = append(req, k)
+			}
+			sort.Strings(req)
+
+			var found bool
+			for _, q := range s.Paths["/quake"][m].Queries {
+				found = found || (q.Summary == rt.doc.Title && reflect.DeepEqual(q.Required, req) && reflect.DeepEqual(q.ContentType, rt.content))
+			}
+
+			if !found {
+				t.Errorf("%s /quake: no x-queries entry for %s with required %v", m, rt.doc.Title, req)
+			}
+		}
+	}
+}
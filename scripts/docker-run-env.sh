@@ -0,0 +1,25 @@
+#!/bin/bash
+
+# Adds the env var that are read by geonet-rest, not by cfg, to the docker-run.sh generated by configer.
+# Run from go generate after configer (see server.go).
+#
+# usage: docker-run-env.sh docker-run.sh
+
+f=${1:-docker-run.sh}
+
+# name=default description
+env_vars=(
+	"GEONET_REST_MAX_QUAKES=10000 the largest number of quakes that can be requested."
+)
+
+for v in "${env_vars[@]}"; do
+	kv=${v%% *}
+	doc=${v#* }
+
+	awk -v kv="$kv" -v doc="$doc" '
+		/^$/ { blank = 1; next }
+		/^docker run / { printf "#\n# %s\n# %s\n", doc, kv; sub(/ [^ ]+$/, " -e \"" kv "\"&") }
+		blank { print ""; blank = 0 }
+		{ print }
+	' "$f" > "$f.tmp" && mv "$f.tmp" "$f"
+done
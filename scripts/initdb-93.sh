@@ -57,5 +57,6 @@ psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/user-perm
 #
 psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/event-test-data.ddl
 psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/event-date-change.ddl
+psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/quake-tombstone-test-data.ddl
 psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/soh-test-data.ddl
 psql --host=127.0.0.1 --quiet --username=$db_user hazard -f ${ddl_dir}/impact-test-data.ddl
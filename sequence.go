@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"html/template"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// /quake/2013p407387/sequence
+
+// sequenceTime is the format for times in the sequence summary.  It matches the quake time property.
+const sequenceTime = "2006-01-02T15:04:05.000Z"
+
+var quakeSequenceD = &apidoc.Query{
+	Title:       "Aftershock Sequence",
+	Description: "quakes after a mainshock within a distance and time window scaled by the mainshock magnitude, ordered by origin time (most recent first).",
+	Discussion: `<p>The window is from <a href="http://www.bssaonline.org/content/64/5/1363">Gardner and Knopoff (1974)</a>.
+	The distance (km) is <code>10<sup>0.1238M + 0.983</sup></code>.  The time (days) is <code>10<sup>0.032M + 2.7389</sup></code> for magnitudes 6.5 and above,
+	otherwise <code>10<sup>0.5409M - 0.547</sup></code>.  Distances are from the mainshock epicenter.  Deleted and duplicate quakes are not included.</p>
+	<p>The response is a GeoJSON FeatureCollection with an additional <code>summary</code> member.</p>`,
+	Example:     "/quake/2013p407387/sequence",
+	ExampleHost: exHost,
+	Params: map[string]template.HTML{
+		`publicID`: `a valid quake ID e.g., <code>2013p407387</code>.`,
+	},
+	Props: mergeHTML(propsD, map[string]template.HTML{
+		`distance`: `the distance (km) from the mainshock epicenter.`,
+		`summary`: `<code>mainshock</code> and <code>largest</code> aftershock (<code>publicID</code>, <code>time</code>, <code>magnitude</code>),
+		the <code>window</code> (<code>radius</code> km, <code>days</code>, and <code>endTime</code>), the <code>count</code> of aftershocks,
+		and <code>dailyRate</code>; the number of aftershocks each day (UTC) from the mainshock until the end of the window or now.`,
+	}),
+}
+
+type sequenceCollection struct {
+	Type     string          `json:"type"`
+	Features json.RawMessage `json:"features"`
+	BBox     json.RawMessage `json:"bbox,omitempty"` // version 2 only.
+	Summary  sequenceSummary `json:"summary"`
+}
+
+type sequenceSummary struct {
+	Mainshock sequenceQuake   `json:"mainshock"`
+	Window    sequenceWindow  `json:"window"`
+	Count     int             `json:"count"`
+	Largest   *sequenceQuake  `json:"largest"`
+	DailyRate []sequenceCount `json:"dailyRate"`
+}
+
+type sequenceQuake struct {
+	PublicID  string  `json:"publicID"`
+	Time      string  `json:"time"`
+	Magnitude float64 `json:"magnitude"`
+}
+
+type sequenceWindow struct {
+	Radius  float64 `json:"radius"`
+	Days    float64 `json:"days"`
+	EndTime string  `json:"endTime"`
+}
+
+type sequenceCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// gardnerKnopoff returns the Gardner and Knopoff (1974) aftershock window for magnitude m
+// as a distance (km) and duration (days).
+func gardnerKnopoff(m float64) (radius, days float64) {
+	radius = math.Pow(10, 0.1238*m+0.983)
+
+	if m >= 6.5 {
+		days = math.Pow(10, 0.032*m+2.7389)
+	} else {
+		days = math.Pow(10, 0.5409*m-0.547)
+	}
+
+	return
+}
+
+func quakeSequence(w http.ResponseWriter, r *http.Request) {
+	publicID := strings.TrimSuffix(r.URL.Path[quakeLen:], "/sequence")
+
+	var f quakeFilter
+	var err error
+
+	if f.format, err = newGeoJSONFormat(r); err != nil {
+		web.BadRequest(w, r, err.Error())
+		return
+	}
+
+	var m sequenceQuake
+	var origin time.Time
+
+	err = db.QueryRow("select publicid, origintime, magnitude FROM qrt.quake_materialized where publicid = $1", publicID).Scan(&m.PublicID, &origin, &m.Magnitude)
+	if err == sql.ErrNoRows {
+		web.NotFound(w, r, "invalid publicID: "+publicID)
+		return
+	}
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	m.Time = origin.UTC().Format(sequenceTime)
+
+	radius, days := gardnerKnopoff(m.Magnitude)
+	end := origin.Add(time.Duration(days * 24 * float64(time.Hour)))
+
+	f.add("status not in ('deleted', 'duplicate')")
+	f.add("publicid != ?", publicID)
+	f.add("origintime > ? AND origintime <= ?", origin, end)
+	f.add("ST_DWithin(q.origin_geom::geography, (SELECT origin_geom FROM qrt.quake_materialized WHERE publicid = ?)::geography, ?)", publicID, radius*1000)
+
+	s := sequenceSummary{
+		Mainshock: m,
+		Window: sequenceWindow{
+			Radius:  math.Floor(radius*100+0.5) / 100,
+			Days:    math.Floor(days*100+0.5) / 100,
+			EndTime: end.UTC().Format(sequenceTime),
+		},
+		DailyRate: []sequenceCount{},
+	}
+
+	if s.Largest, err = f.sequenceLargest(); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	daily, err := f.sequenceDaily()
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	// a count for every day from the mainshock until the end of the window or now.
+	last := end
+	if now := time.Now().UTC(); now.Before(last) {
+		last = now
+	}
+
+	for d := origin.UTC().Truncate(24 * time.Hour); !d.After(last); d = d.Add(24 * time.Hour) {
+		c := sequenceCount{Date: d.Format("2006-01-02"), Count: daily[d.Format("2006-01-02")]}
+		s.Count += c.Count
+		s.DailyRate = append(s.DailyRate, c)
+	}
+
+	// the distance property is for the quakes in the response only.
+	f.addProp("distance", `round((ST_Distance(q.origin_geom::geography, (SELECT origin_geom FROM qrt.quake_materialized WHERE publicid = ?)::geography) / 1000)::numeric, 2)`, publicID)
+
+	d, err := f.featureCollection(maxQuakes)
+	if err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	q := sequenceCollection{Summary: s}
+
+	if err = json.Unmarshal([]byte(d), &q); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	var b bytes.Buffer
+	if err = json.NewEncoder(&b).Encode(q); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+
+	web.OkBuf(w, r, &b)
+}
+
+// sequenceLargest returns the largest magnitude quake selected by the filter or nil if there are none.
+func (f *quakeFilter) sequenceLargest() (*sequenceQuake, error) {
+	var q sequenceQuake
+	var t time.Time
+
+	err := db.QueryRow(`SELECT publicid, origintime, magnitude FROM qrt.quake_materialized as q
+		WHERE `+strings.Join(f.where, " AND ")+` ORDER BY magnitude DESC, origintime ASC LIMIT 1`, f.args...).Scan(&q.PublicID, &t, &q.Magnitude)
+	switch err {
+	case nil:
+		q.Time = t.UTC().Format(sequenceTime)
+		return &q, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// sequenceDaily returns the number of quakes selected by the filter for each day (UTC).
+// The map key is the date formatted as 2006-01-02.
+func (f *quakeFilter) sequenceDaily() (map[string]int, error) {
+	rows, err := db.Query(`SELECT to_char(origintime AT TIME ZONE 'UTC', 'YYYY-MM-DD'), count(*) FROM qrt.quake_materialized as q
+		WHERE `+strings.Join(f.where, " AND ")+` GROUP BY 1`, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daily := make(map[string]int)
+
+	for rows.Next() {
+		var d string
+		var c int
+
+		if err = rows.Scan(&d, &c); err != nil {
+			return nil, err
+		}
+
+		daily[d] = c
+	}
+
+	return daily, rows.Err()
+}
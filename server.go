@@ -12,6 +12,7 @@ import (
 )
 
 //go:generate configer geonet-rest.json
+//go:generate scripts/docker-run-env.sh docker-run.sh
 var (
 	config = cfg.Load()
 	db     *sql.DB
@@ -57,8 +58,56 @@ func main() {
 }
 
 // handler creates a mux and wraps it with default handlers.  Seperate function to enable testing.
+// http POST requests are sent to postRouter, all other requests must be GET.
+// Routes that stream their response, e.g., the quake stream, are not gzipped so that events can be flushed to the client.
 func handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", router)
-	return header.GetGzip(mux)
+	get := header.GetGzip(mux)
+	post := postHandler(web.GzipHandler(http.HandlerFunc(postRouter)))
+	events := streamHandler(http.HandlerFunc(router))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST":
+			post.ServeHTTP(w, r)
+		case r.Method == "GET" && streams(r):
+			events.ServeHTTP(w, r)
+		default:
+			get.ServeHTTP(w, r)
+		}
+	})
+}
+
+// postHandler wraps h for http POST requests.  web.Header only handles GET requests.  POST responses are not
+// cached.  The response counts are recorded by the web response funcs e.g., web.Ok, the same as for GET.
+func postHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			web.MethodNotAllowed(w, r)
+			return
+		}
+
+		log.Printf("POST %s", r.URL)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Add("Vary", header.Vary)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// streamHandler wraps h for long lived GET responses e.g., the quake stream.  Unlike web.Header the response
+// time is not tracked, it would be the life of the connection, and Vary is not set; the response is not cached.
+// The response is not gzipped so that it can be flushed.
+func streamHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			web.MethodNotAllowed(w, r)
+			return
+		}
+
+		log.Printf("GET %s", r.URL)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Surrogate-Control", "no-cache")
+		h.ServeHTTP(w, r)
+	})
 }
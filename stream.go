@@ -0,0 +1,304 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/GeoNet/web"
+	"github.com/GeoNet/web/api/apidoc"
+	"github.com/lib/pq"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Quake changes are streamed to clients as Server-Sent Events.  The qrt.event triggers
+// notify on the quake channel with the publicid of a changed quake.  A single
+// database listener is shared by all connected clients.
+
+const (
+	streamPath      = "/quake/stream"
+	streamChannel   = "quake"
+	eventStream     = "text/event-stream"
+	streamKeepAlive = 30 * time.Second // comments are sent this often to stop proxies closing idle connections.
+	streamReady     = 5 * time.Second  // how long to wait for the database listener to start.
+	streamBuffer    = 16               // events buffered per client.  Slow clients are disconnected.
+)
+
+var quakeStreamD = &apidoc.Query{
+	Title:       "Quake Stream",
+	Description: "a stream of quakes as they are inserted, updated, or deleted.",
+	Discussion: `<p>A <a href="http://www.w3.org/TR/eventsource/">Server-Sent Events</a> stream for use with an <code>EventSource</code>.
+	Each <code>quake</code> event is a GeoJSON Feature with the same properties as for a single quake.
+	If a quake is removed from the database a <code>delete</code> event is sent with a Feature that has a <code>null</code> geometry and only the <code>publicID</code> property.
+	Delete events are not filtered.  Comment lines are sent every 30 seconds to keep the connection open.</p>
+	<p>If the stream loses its connection to the database then quake changes may be missed.  When the connection is
+	re-established a <code>resync</code> event is sent with the data <code>{}</code>.  Clients should then re-fetch the quakes
+	they need e.g., with <code>/quake/changes</code>.  Resync events are not filtered.</p>`,
+	Optional: map[string]template.HTML{
+		`regionID`: `only stream quakes in this quake region e.g., <code>newzealand</code>.`,
+		`intensity`: `only stream quakes with at least this intensity at the epicenter e.g., <code>weak</code>.
+		Must be one of <code>unnoticeable</code>, <code>weak</code>, <code>light</code>,
+		<code>moderate</code>, <code>strong</code>, <code>severe</code>.`,
+	},
+	Props: propsD,
+}
+
+var stream = quakeStream{
+	clients: make(map[*streamClient]bool),
+}
+
+// quakeStream sends quake events to subscribed clients.
+type quakeStream struct {
+	sync.Mutex
+	start   *streamStart // the current start of the database listener.  nil until the first subscribe or after a failed start.
+	clients map[*streamClient]bool
+}
+
+// streamStart is an attempt to start the database listener.  done is closed when the listener is
+// listening or has failed to start.  err is set before done is closed if the listener failed to start.
+type streamStart struct {
+	done chan struct{}
+	err  error
+}
+
+// streamClient is a client subscribed to the quake stream.  An empty regionID is all regions and
+// an empty intensity is all intensities.
+type streamClient struct {
+	regionID  string
+	intensity string
+	events    chan streamEvent
+}
+
+// streamEvent is a change to a quake.  name is the SSE event name.  intensity is from qrt.mmi_to_intensity.
+type streamEvent struct {
+	name      string
+	data      []byte
+	intensity string
+	regions   []string
+}
+
+// resyncEvent is sent when the database listener reconnects and notifications may have been missed.
+var resyncEvent = streamEvent{name: "resync", data: []byte("{}")}
+
+// match returns true if e should be sent to c.
+func (c *streamClient) match(e streamEvent) bool {
+	if e.name == "delete" || e.name == "resync" {
+		return true
+	}
+
+	if intensityRank(e.intensity) < intensityRank(c.intensity) {
+		return false
+	}
+
+	return c.regionID == "" || contains(e.regions, c.regionID)
+}
+
+// intensityRank returns the order of the intensity i from unnoticeable (0) to severe.  -1 if i is not an intensity.
+func intensityRank(i string) int {
+	for n, v := range queryParams["intensity"].values {
+		if v == i {
+			return n
+		}
+	}
+
+	return -1
+}
+
+// subscribe adds c to the stream.  The database listener is started the first time
+// subscribe is called and again after it fails to start.  An error is returned if the
+// listener fails to start or is not ready in time.
+func (s *quakeStream) subscribe(c *streamClient) error {
+	s.Lock()
+	if s.start == nil {
+		s.start = &streamStart{done: make(chan struct{})}
+		go s.listen(s.start)
+	}
+	st := s.start
+	s.Unlock()
+
+	select {
+	case <-st.done:
+		if st.err != nil {
+			return st.err
+		}
+	case <-time.After(streamReady):
+		return fmt.Errorf("quake stream listener not ready")
+	}
+
+	s.Lock()
+	s.clients[c] = true
+	s.Unlock()
+
+	return nil
+}
+
+// unsubscribe removes c from the stream.
+func (s *quakeStream) unsubscribe(c *streamClient) {
+	s.Lock()
+	delete(s.clients, c)
+	s.Unlock()
+}
+
+// listen starts the database listener for st, then receives notifications from the database and
+// publishes them to clients.  The listener reconnects to the database as needed.  If the listener
+// can't be started then the error is set on st and the next subscribe starts a new listener.
+func (s *quakeStream) listen(st *streamStart) {
+	l := pq.NewListener(config.DataBase.Postgres(), 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("WARN quake stream listener: %s", err)
+		}
+	})
+
+	if err := l.Listen(streamChannel); err != nil {
+		log.Printf("ERROR quake stream listener: %s", err)
+		l.Close()
+
+		s.Lock()
+		st.err = fmt.Errorf("quake stream listener: %s", err)
+		s.start = nil
+		s.Unlock()
+
+		close(st.done)
+		return
+	}
+
+	close(st.done)
+
+	for {
+		select {
+		case n := <-l.Notify:
+			// a nil notification means the connection was re-established and notifications may have been missed.
+			// Clients are told to resync.
+			if n == nil {
+				log.Print("WARN quake stream listener reconnected, sending resync")
+				s.publish(resyncEvent)
+				continue
+			}
+
+			e, err := streamQuake(n.Extra)
+			if err != nil {
+				log.Printf("WARN quake stream: %s", err)
+				continue
+			}
+
+			s.publish(e)
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
+
+// publish sends e to the matching clients.  Clients that are not keeping up are disconnected.
+func (s *quakeStream) publish(e streamEvent) {
+	s.Lock()
+	defer s.Unlock()
+
+	for c := range s.clients {
+		if !c.match(e) {
+			continue
+		}
+
+		select {
+		case c.events <- e:
+		default:
+			close(c.events)
+			delete(s.clients, c)
+		}
+	}
+}
+
+// streamFormat is the format for quake events; version 1 with all the properties.
+var streamFormat = geoJSONFormat{precision: -1}
+
+// streamQuake returns the stream event for the quake publicID.  Quakes that are not
+// in qrt.quake_materialized have been deleted.
+func streamQuake(publicID string) (e streamEvent, err error) {
+	var d, regions string
+
+	err = db.QueryRow(
+		`SELECT row_to_json((SELECT f FROM (SELECT `+streamFormat.quakeFeature(quakeProps)+`) as f)),
+                         qrt.mmi_to_intensity(maxmmi),
+                         array_to_string(array(SELECT regionname FROM qrt.region WHERE groupname in ('region', 'north', 'south')
+                         	AND ST_Contains(geom, ST_Shift_Longitude(q.origin_geom))), ',')
+                         FROM qrt.quake_materialized as q where publicid = $1`, publicID).Scan(&d, &e.intensity, &regions)
+	switch err {
+	case nil:
+		e.name = "quake"
+		e.data = []byte(d)
+		e.regions = strings.Split(regions, ",")
+	case sql.ErrNoRows:
+		err = nil
+		e.name = "delete"
+		e.data = []byte(`{"type":"Feature","geometry":null,"properties":{"publicID":"` + publicID + `"}}`)
+	}
+
+	return
+}
+
+// quakeStreamHandler streams quake events to the client until the client disconnects.
+// It is wrapped with streamHandler, not the gzip handler, so that each event can be flushed.
+func quakeStreamHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+
+	c := &streamClient{
+		events: make(chan streamEvent, streamBuffer),
+	}
+
+	if c.intensity = v.Get("intensity"); c.intensity != "" && !intensityRe.MatchString(c.intensity) {
+		web.BadRequest(w, r, "Invalid intensity: "+c.intensity)
+		return
+	}
+
+	if c.regionID = v.Get("regionID"); c.regionID != "" {
+		var d string
+		err := db.QueryRow("select regionname FROM qrt.region where regionname = $1 AND groupname in ('region', 'north', 'south')", c.regionID).Scan(&d)
+		if err == sql.ErrNoRows {
+			web.BadRequest(w, r, "invalid quake regionID: "+c.regionID)
+			return
+		}
+		if err != nil {
+			web.ServiceUnavailable(w, r, err)
+			return
+		}
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		web.ServiceUnavailable(w, r, fmt.Errorf("streaming not supported by the ResponseWriter"))
+		return
+	}
+
+	if err := stream.subscribe(c); err != nil {
+		web.ServiceUnavailable(w, r, err)
+		return
+	}
+	defer stream.unsubscribe(c)
+
+	w.Header().Set("Content-Type", eventStream)
+
+	fmt.Fprint(w, ": connected\n\n")
+	f.Flush()
+	web.OkTrack(w, r)
+
+	k := time.NewTicker(streamKeepAlive)
+	defer k.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-k.C:
+			fmt.Fprint(w, ": keep alive\n\n")
+			f.Flush()
+		case e, ok := <-c.events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+			f.Flush()
+		}
+	}
+}